@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type addBookInput struct {
+	Path           string   `json:"path"`
+	Title          string   `json:"title,omitempty"`
+	Authors        []string `json:"authors,omitempty"`
+	AllowDuplicate bool     `json:"allow_duplicate,omitempty"`
+	DryRun         bool     `json:"dry_run,omitempty"`
+}
+
+type addBookOutput struct {
+	Result *calibre.AddResult `json:"result"`
+}
+
+// resolveInboxPath returns the location of a file of the inbox, given
+// relative to it or as an absolute path, refusing files outside of it
+func resolveInboxPath(inbox string, name string) (string, error) {
+	root, err := filepath.EvalSymlinks(inbox)
+	if err != nil {
+		return "", fmt.Errorf("inbox: %w", err)
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(inbox, name)
+	}
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in the inbox directory", name)
+	}
+	return resolved, nil
+}
+
+func addBook(ctx context.Context, req *mcp.CallToolRequest, input addBookInput, db *calibre.DB, libraryPath string, inbox string) (
+	*mcp.CallToolResult,
+	*addBookOutput,
+	error,
+) {
+	result, err := func() (*calibre.AddResult, error) {
+		filePath, err := resolveInboxPath(inbox, input.Path)
+		if err != nil {
+			return nil, err
+		}
+		return calibre.AddBook(ctx, db, libraryPath, filePath, calibre.AddOptions{
+			Title:          input.Title,
+			Authors:        input.Authors,
+			AllowDuplicate: input.AllowDuplicate,
+			DryRun:         input.DryRun,
+		})
+	}()
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+	if result.BookID != 0 {
+		calibre.ClearCaches()
+		clearBooksSearchCache()
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: formatAddResult(input.Path, result, input.DryRun)},
+		},
+	}, &addBookOutput{Result: result}, nil
+}
+
+func formatAddResult(name string, result *calibre.AddResult, dryRun bool) string {
+	meta := result.Metadata
+	var lines []string
+	switch {
+	case result.BookID != 0 && result.Warning != "":
+		lines = append(lines, fmt.Sprintf("Added %s as book ID %d in %s. Warning: %s", name, result.BookID, result.Path, result.Warning))
+	case result.BookID != 0:
+		lines = append(lines, fmt.Sprintf("Added %s as book ID %d in %s, change %d", name, result.BookID, result.Path, result.ChangeID))
+	case dryRun:
+		lines = append(lines, fmt.Sprintf("Dry run: %s would be added with this metadata", name))
+	default:
+		lines = append(lines, fmt.Sprintf("%s was not added, as the library seems to have it already", name))
+	}
+
+	lines = append(lines, fmt.Sprintf("   Title: %s", meta.Title))
+	lines = append(lines, fmt.Sprintf("   Authors: %s", strings.Join(meta.Authors, ", ")))
+	lines = append(lines, fmt.Sprintf("   Format: %s", meta.Format))
+	if len(meta.Languages) > 0 {
+		lines = append(lines, fmt.Sprintf("   Languages: %s", strings.Join(meta.Languages, ", ")))
+	}
+	if len(meta.Identifiers) > 0 {
+		var identifiers []string
+		for _, typ := range slices.Sorted(maps.Keys(meta.Identifiers)) {
+			identifiers = append(identifiers, typ+":"+meta.Identifiers[typ])
+		}
+		lines = append(lines, fmt.Sprintf("   Identifiers: %s", strings.Join(identifiers, ", ")))
+	}
+	if meta.HasCover {
+		lines = append(lines, "   Cover: yes")
+	}
+
+	if len(result.Duplicates) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, "Possible duplicates in the library:")
+		for _, c := range result.Duplicates {
+			lines = append(lines, "   - "+candidateLabel(c))
+		}
+		if result.BookID == 0 && !dryRun {
+			lines = append(lines, "Set allow_duplicate to add it anyway.")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runAdd adds the given files of the inbox to the library, returning the exit
+// status: 1 if some files were skipped as duplicates, 2 on errors
+func runAdd(cfg config, args []string) int {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	title := flags.String("title", "", "Title to use instead of the one read from the file")
+	authors := flags.String("authors", "", "Authors to use instead of the ones read from the file, separated by &")
+	allowDuplicate := flags.Bool("allow-duplicate", false, "Add the books even if the library seems to have them already")
+	dryRun := flags.Bool("dry-run", false, "Only show the metadata and the possible duplicates")
+	jsonOutput := flags.Bool("json", false, "Print the results as JSON")
+	flags.Parse(args)
+
+	if cfg.inbox == "" {
+		fmt.Fprintln(os.Stderr, "Set -inbox to the directory to add books from")
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "No file to add")
+		return 2
+	}
+
+	db, err := calibre.OpenLibrary(cfg.libraryPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open Calibre library: %v\n", err)
+		return 2
+	}
+	defer db.Close()
+	journal, err := openJournal(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open the journal of changes: %v\n", err)
+		return 2
+	}
+	db.SetJournal(journal)
+
+	opts := calibre.AddOptions{Title: *title, AllowDuplicate: *allowDuplicate, DryRun: *dryRun}
+	for _, author := range strings.Split(*authors, "&") {
+		if author = strings.TrimSpace(author); author != "" {
+			opts.Authors = append(opts.Authors, author)
+		}
+	}
+
+	status := 0
+	for _, name := range flags.Args() {
+		result, err := func() (*calibre.AddResult, error) {
+			filePath, err := resolveInboxPath(cfg.inbox, name)
+			if err != nil {
+				return nil, err
+			}
+			return calibre.AddBook(context.Background(), db, cfg.libraryPath, filePath, opts)
+		}()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add %s: %v\n", name, err)
+			status = 2
+			continue
+		}
+		if result.BookID == 0 && !*dryRun && status == 0 {
+			status = 1
+		}
+
+		if *jsonOutput {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(result); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+		} else {
+			fmt.Println(formatAddResult(name, result, *dryRun))
+		}
+	}
+	return status
+}
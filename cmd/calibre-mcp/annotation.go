@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type getBookAnnotationsInput struct {
+	BookID int `json:"book_id"`
+}
+
+type searchAnnotationsInput struct {
+	Query  string `json:"query"`
+	Limit  int    `json:"limit,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+}
+
+type annotationsOutput struct {
+	Annotations []calibre.Annotation `json:"annotations"`
+	TotalNum    int                  `json:"total_num"`
+}
+
+func getBookAnnotations(ctx context.Context, req *mcp.CallToolRequest, input getBookAnnotationsInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*annotationsOutput,
+	error,
+) {
+	annotations, err := calibre.GetBookAnnotations(ctx, db, libraryPath, input.BookID)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("Annotations of book ID %d:", input.BookID))
+	if len(annotations) == 0 {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, "No annotations found.")
+	}
+	for _, a := range annotations {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, formatAnnotation(a)...)
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &annotationsOutput{Annotations: annotations, TotalNum: len(annotations)}, nil
+}
+
+func searchAnnotations(ctx context.Context, req *mcp.CallToolRequest, input searchAnnotationsInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*annotationsOutput,
+	error,
+) {
+	result, err := calibre.SearchAnnotations(ctx, db, libraryPath, input.Query, input.Limit, input.Offset)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("Found %d annotations matching '%s':", result.TotalNum, input.Query))
+	for _, a := range result.Annotations {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, fmt.Sprintf("%s (ID: %d)", a.BookTitle, a.BookID))
+		contentLines = append(contentLines, formatAnnotation(a)...)
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &annotationsOutput{Annotations: result.Annotations, TotalNum: result.TotalNum}, nil
+}
+
+func formatAnnotation(a calibre.Annotation) []string {
+	var lines []string
+	switch {
+	case a.Type == "bookmark":
+		lines = append(lines, fmt.Sprintf("- Bookmark: %s", a.Title))
+	case a.Color != "":
+		lines = append(lines, fmt.Sprintf("- Highlight (%s): \"%s\"", a.Color, a.HighlightedText))
+	default:
+		lines = append(lines, fmt.Sprintf("- Highlight: \"%s\"", a.HighlightedText))
+	}
+	if a.Notes != "" {
+		lines = append(lines, fmt.Sprintf("  Notes: %s", a.Notes))
+	}
+	if a.Location != nil {
+		lines = append(lines, fmt.Sprintf("  Chapter %d: %s, offset %d", a.Location.ChapterIndex, a.Location.ChapterTitle, a.Location.Offset))
+		lines = append(lines, fmt.Sprintf("  Context: %s", a.Location.Context))
+	}
+	lines = append(lines, fmt.Sprintf("  Date: %s, by %s (%s)", a.Timestamp, a.User, a.Format))
+	return lines
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// defaultBrowseLimit caps the items returned when no limit is given, as
+// large libraries have thousands of authors and tags
+const defaultBrowseLimit = 100
+
+type browseCategoryInput struct {
+	Category string `json:"category"`
+	Prefix   string `json:"prefix,omitempty"`
+	Sort     string `json:"sort,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+	Offset   int    `json:"offset,omitempty"`
+}
+
+type browseCategoryOutput struct {
+	Results *calibre.CategoryResult `json:"results"`
+}
+
+type getCategoryBooksInput struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Limit    int    `json:"limit,omitempty"`
+	Offset   int    `json:"offset,omitempty"`
+}
+
+type categoryBooksOutput struct {
+	Results *calibre.SearchResult `json:"results"`
+	Note    *calibre.Note         `json:"note,omitempty"`
+}
+
+// categoryName accepts singular category names, as used by the completion
+// arguments, in place of the plural ones
+func categoryName(category string) string {
+	category = strings.ToLower(strings.TrimSpace(category))
+	if field, ok := completionFields[category]; ok {
+		return field
+	}
+	return category
+}
+
+func browseLimit(limit int) int {
+	if limit <= 0 {
+		return defaultBrowseLimit
+	}
+	return limit
+}
+
+func browseCategory(ctx context.Context, req *mcp.CallToolRequest, input browseCategoryInput, db *calibre.DB) (
+	*mcp.CallToolResult,
+	*browseCategoryOutput,
+	error,
+) {
+	category := categoryName(input.Category)
+	results, err := calibre.BrowseCategory(ctx, db, category, calibre.BrowseOptions{
+		Prefix: input.Prefix,
+		SortBy: input.Sort,
+		Limit:  browseLimit(input.Limit),
+		Offset: input.Offset,
+	})
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("%s%s:", strings.ToUpper(category[:1]), category[1:]))
+	contentLines = append(contentLines, "")
+	for _, item := range results.Items {
+		line := fmt.Sprintf("- %s (%d books)", item.Name, item.Count)
+		if item.Sort != "" && item.Sort != item.Name {
+			line += fmt.Sprintf(", sorted as %s", item.Sort)
+		}
+		if item.Link != "" {
+			line += fmt.Sprintf(", %s", item.Link)
+		}
+		contentLines = append(contentLines, line)
+		if item.Note != "" {
+			contentLines = append(contentLines, fmt.Sprintf("  Note: %s", noteExcerpt(item.Note)))
+		}
+	}
+	contentLines = append(contentLines, "")
+	contentLines = append(contentLines, fmt.Sprintf("Total results: %d", results.TotalNum))
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &browseCategoryOutput{Results: results}, nil
+}
+
+func getCategoryBooks(ctx context.Context, req *mcp.CallToolRequest, input getCategoryBooksInput, db *calibre.DB) (
+	*mcp.CallToolResult,
+	*categoryBooksOutput,
+	error,
+) {
+	category := categoryName(input.Category)
+	results, err := calibre.GetCategoryBooks(ctx, db, category, input.Name, browseLimit(input.Limit), input.Offset)
+	var note *calibre.Note
+	if err == nil {
+		note, err = calibre.GetNote(ctx, db, category, input.Name)
+	}
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("Books for %s '%s':", category, input.Name))
+	contentLines = append(contentLines, "")
+	if note != nil {
+		contentLines = append(contentLines, fmt.Sprintf("Note: %s", note.Text))
+		if len(note.Resources) > 0 {
+			contentLines = append(contentLines, fmt.Sprintf("Resources: %s", strings.Join(note.Resources, ", ")))
+		}
+		contentLines = append(contentLines, "")
+	}
+	for i, book := range results.Books {
+		contentLines = append(
+			contentLines,
+			fmt.Sprintf("%d. %s by %s (ID: %d)", input.Offset+i+1, book.Title, strings.Join(book.Authors, ", "), book.ID),
+		)
+		if book.Series != "" {
+			contentLines = append(contentLines, fmt.Sprintf("   Series: %s #%g", book.Series, book.SeriesIndex))
+		}
+		contentLines = append(contentLines, fmt.Sprintf("   Formats: %s", strings.Join(book.Formats, ", ")))
+	}
+	contentLines = append(contentLines, "")
+	contentLines = append(contentLines, fmt.Sprintf("Total results: %d", results.TotalNum))
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &categoryBooksOutput{Results: results, Note: note}, nil
+}
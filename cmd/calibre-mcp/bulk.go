@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// editPlanTTL is how long a bulk edit plan can be applied after it was made
+const editPlanTTL = 15 * time.Minute
+
+type bulkEditInput struct {
+	Query     string   `json:"query,omitempty"`
+	Operation string   `json:"operation,omitempty"`
+	Value     string   `json:"value,omitempty"`
+	From      []string `json:"from,omitempty"`
+	PlanToken string   `json:"plan_token,omitempty"`
+}
+
+type bulkEditOutput struct {
+	DryRun    bool                 `json:"dry_run"`
+	PlanToken string               `json:"plan_token,omitempty"`
+	ChangeID  int                  `json:"change_id,omitempty"`
+	Changes   []calibre.BookChange `json:"changes"`
+}
+
+type editPlanEntry struct {
+	plan      *calibre.EditPlan
+	timestamp time.Time
+}
+
+var (
+	editPlans   = make(map[string]editPlanEntry)
+	editPlansMu sync.Mutex
+)
+
+// storeEditPlan keeps a plan until it is applied or expires, and returns its
+// token
+func storeEditPlan(plan *calibre.EditPlan) string {
+	var b [16]byte
+	rand.Read(b[:])
+	token := hex.EncodeToString(b[:])
+
+	editPlansMu.Lock()
+	defer editPlansMu.Unlock()
+	for t, entry := range editPlans {
+		if time.Since(entry.timestamp) > editPlanTTL {
+			delete(editPlans, t)
+		}
+	}
+	editPlans[token] = editPlanEntry{plan: plan, timestamp: time.Now()}
+	return token
+}
+
+// takeEditPlan removes the plan of a token and returns it, or nil if the token
+// is unknown or expired
+func takeEditPlan(token string) *calibre.EditPlan {
+	editPlansMu.Lock()
+	defer editPlansMu.Unlock()
+	entry, ok := editPlans[token]
+	delete(editPlans, token)
+	if !ok || time.Since(entry.timestamp) > editPlanTTL {
+		return nil
+	}
+	return entry.plan
+}
+
+func bulkEdit(ctx context.Context, req *mcp.CallToolRequest, input bulkEditInput, db *calibre.DB) (
+	*mcp.CallToolResult,
+	*bulkEditOutput,
+	error,
+) {
+	output, err := func() (*bulkEditOutput, error) {
+		if input.PlanToken != "" {
+			plan := takeEditPlan(input.PlanToken)
+			if plan == nil {
+				return nil, fmt.Errorf("unknown or expired plan token, make a new plan")
+			}
+			set, err := calibre.ApplyEditPlan(ctx, db, plan)
+			if err != nil {
+				return nil, err
+			}
+			calibre.ClearCaches()
+			clearBooksSearchCache()
+			return &bulkEditOutput{ChangeID: set.ID, Changes: set.Changes}, nil
+		}
+
+		plan, err := calibre.PlanBulkEdit(ctx, db, calibre.BulkEdit{
+			Query:     input.Query,
+			Operation: input.Operation,
+			Value:     input.Value,
+			From:      input.From,
+		})
+		if err != nil {
+			return nil, err
+		}
+		output := &bulkEditOutput{DryRun: true, Changes: plan.Changes}
+		if len(plan.Changes) > 0 {
+			output.PlanToken = storeEditPlan(plan)
+		}
+		return output, nil
+	}()
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	switch {
+	case len(output.Changes) == 0:
+		contentLines = append(contentLines, "No book would change")
+	case output.DryRun:
+		contentLines = append(contentLines, fmt.Sprintf("Dry run: %d books would change. Nothing was written yet.", len(output.Changes)))
+	default:
+		contentLines = append(contentLines, fmt.Sprintf("Updated %d books, change %d:", len(output.Changes), output.ChangeID))
+	}
+	for _, change := range output.Changes {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, fmt.Sprintf("%s (ID: %d)", change.Title, change.BookID))
+		contentLines = append(contentLines, formatFieldChanges(change.Changes, "  ")...)
+	}
+	if output.PlanToken != "" {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, fmt.Sprintf("Call bulk_edit with plan_token %s within %s to apply these changes.",
+			output.PlanToken, editPlanTTL))
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, output, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type checkLibraryInput struct{}
+
+type checkLibraryOutput struct {
+	Report *calibre.LibraryReport `json:"report"`
+}
+
+// formatLibraryReport renders a report as text, for the tool and the check
+// subcommand
+func formatLibraryReport(report *calibre.LibraryReport) string {
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("Checked %d books and %d files", report.Books, report.Files))
+	contentLines = append(contentLines, fmt.Sprintf("SQLite integrity check: %s", strings.Join(report.IntegrityCheck, "; ")))
+	if report.OK() {
+		contentLines = append(contentLines, "No problems found")
+		return strings.Join(contentLines, "\n")
+	}
+
+	kinds := make([]string, 0, len(report.Counts))
+	for kind, count := range report.Counts {
+		kinds = append(kinds, fmt.Sprintf("%s: %d", kind, count))
+	}
+	sort.Strings(kinds)
+	contentLines = append(contentLines, fmt.Sprintf("%d problems found (%s)", len(report.Issues), strings.Join(kinds, ", ")))
+	contentLines = append(contentLines, "")
+	for _, issue := range report.Issues {
+		line := fmt.Sprintf("- [%s]", issue.Kind)
+		if issue.BookID != 0 {
+			line += fmt.Sprintf(" book ID %d:", issue.BookID)
+		}
+		if issue.Path != "" {
+			line += fmt.Sprintf(" %s:", issue.Path)
+		}
+		contentLines = append(contentLines, line+" "+issue.Message)
+	}
+	return strings.Join(contentLines, "\n")
+}
+
+func checkLibrary(ctx context.Context, req *mcp.CallToolRequest, input checkLibraryInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*checkLibraryOutput,
+	error,
+) {
+	report, err := calibre.CheckLibrary(ctx, db, libraryPath)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: formatLibraryReport(report)},
+		},
+	}, &checkLibraryOutput{Report: report}, nil
+}
+
+// runCheck implements the check subcommand, which prints the library report
+// and exits with status 1 when problems were found
+func runCheck(cfg config, args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	flags.Parse(args)
+
+	db, err := calibre.OpenLibrary(cfg.libraryPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open Calibre library: %v\n", err)
+		return 2
+	}
+	defer db.Close()
+
+	report, err := calibre.CheckLibrary(context.Background(), db, cfg.libraryPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to check library: %v\n", err)
+		return 2
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	} else {
+		fmt.Println(formatLibraryReport(report))
+	}
+
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type getComicPagesInput struct {
+	BookID int `json:"book_id"`
+}
+
+type getComicPagesOutput struct {
+	Comic *calibre.Comic `json:"comic"`
+}
+
+type getComicPageInput struct {
+	BookID       int `json:"book_id"`
+	PageIndex    int `json:"page_index"`
+	MaxDimension int `json:"max_dimension,omitempty"`
+}
+
+type getComicPageOutput struct {
+	PageIndex int            `json:"page_index"`
+	Image     *calibre.Image `json:"image"`
+}
+
+func getComicPages(ctx context.Context, req *mcp.CallToolRequest, input getComicPagesInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*getComicPagesOutput,
+	error,
+) {
+	comic, err := calibre.GetComic(ctx, db, libraryPath, input.BookID)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("Comic pages for book ID %d (%s):", input.BookID, comic.Format))
+	if info := comic.Info; info != nil {
+		contentLines = append(contentLines, "")
+		if info.Title != "" {
+			contentLines = append(contentLines, fmt.Sprintf("**Title:** %s", info.Title))
+		}
+		if info.Series != "" {
+			contentLines = append(contentLines, fmt.Sprintf("**Series:** %s #%s", info.Series, info.Number))
+		}
+		if info.Writer != "" {
+			contentLines = append(contentLines, fmt.Sprintf("**Writer:** %s", info.Writer))
+		}
+		if info.Penciller != "" {
+			contentLines = append(contentLines, fmt.Sprintf("**Penciller:** %s", info.Penciller))
+		}
+		if info.Summary != "" {
+			contentLines = append(contentLines, "**Summary:**")
+			contentLines = append(contentLines, info.Summary)
+		}
+	}
+	contentLines = append(contentLines, "")
+	for _, page := range comic.Pages {
+		contentLines = append(contentLines, fmt.Sprintf("%d. %s", page.Index, page.Name))
+	}
+	contentLines = append(contentLines, "")
+	contentLines = append(contentLines, fmt.Sprintf("Total pages: %d", len(comic.Pages)))
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &getComicPagesOutput{Comic: comic}, nil
+}
+
+func getComicPage(ctx context.Context, req *mcp.CallToolRequest, input getComicPageInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*getComicPageOutput,
+	error,
+) {
+	image, err := calibre.GetComicPage(ctx, db, libraryPath, input.BookID, input.PageIndex, input.MaxDimension)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.ImageContent{Data: image.Data, MIMEType: image.MIMEType},
+		},
+	}, &getComicPageOutput{PageIndex: input.PageIndex, Image: image}, nil
+}
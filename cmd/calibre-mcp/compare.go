@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type compareMetadataInput struct {
+	ID int `json:"id,omitempty"`
+}
+
+type compareMetadataOutput struct {
+	Report *calibre.MetadataReport `json:"report"`
+}
+
+func compareMetadata(ctx context.Context, req *mcp.CallToolRequest, input compareMetadataInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*compareMetadataOutput,
+	error,
+) {
+	report, err := calibre.CompareMetadata(ctx, db, libraryPath, input.ID)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("Compared the embedded metadata of %d EPUB files with the library", report.Books))
+	if len(report.Results) == 0 {
+		contentLines = append(contentLines, "No differences found")
+	} else {
+		fields := make([]string, 0, len(report.Counts))
+		for field, count := range report.Counts {
+			fields = append(fields, fmt.Sprintf("%s: %d", field, count))
+		}
+		sort.Strings(fields)
+		contentLines = append(contentLines, fmt.Sprintf("%d books differ (%s)", len(report.Results), strings.Join(fields, ", ")))
+	}
+	for _, book := range report.Results {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, fmt.Sprintf("%s (ID: %d)", book.Title, book.BookID))
+		contentLines = append(contentLines, fmt.Sprintf("  File: %s", book.File))
+		if book.Error != "" {
+			contentLines = append(contentLines, fmt.Sprintf("  Error: %s", book.Error))
+		}
+		for _, mismatch := range book.Mismatches {
+			contentLines = append(contentLines, fmt.Sprintf("  - %s: library %s, file %s",
+				mismatch.Field, formatFieldValue(mismatch.Library), formatFieldValue(mismatch.File)))
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &compareMetadataOutput{Report: report}, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// maxCompletions is the most values a completion may return, per the MCP spec
+const maxCompletions = 100
+
+// completionFields maps prompt and resource template arguments to the library
+// field that completes them
+var completionFields = map[string]string{
+	"author":    "authors",
+	"authors":   "authors",
+	"tag":       "tags",
+	"tags":      "tags",
+	"series":    "series",
+	"publisher": "publishers",
+	"language":  "languages",
+	"lang":      "languages",
+	"fmt":       "formats",
+	"format":    "formats",
+	"title":     "titles",
+}
+
+// bookIDArguments lists the arguments that take a book ID
+var bookIDArguments = map[string]bool{
+	"id":            true,
+	"book_id":       true,
+	"other_book_id": true,
+}
+
+func complete(ctx context.Context, req *mcp.CompleteRequest, db *calibre.DB) (*mcp.CompleteResult, error) {
+	arg := req.Params.Argument
+
+	var values []string
+	var total int
+	var err error
+	if bookIDArguments[arg.Name] {
+		values, total, err = calibre.CompleteBookIDs(ctx, db, arg.Value, maxCompletions)
+	} else if field, ok := completionFields[arg.Name]; ok {
+		values, total, err = calibre.Complete(ctx, db, field, arg.Value, maxCompletions)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = []string{}
+	}
+
+	return &mcp.CompleteResult{
+		Completion: mcp.CompletionResultDetails{
+			Values:  values,
+			Total:   total,
+			HasMore: total > len(values),
+		},
+	}, nil
+}
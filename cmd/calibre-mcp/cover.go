@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// defaultCoverMaxDimension bounds covers attached to get_book results
+const defaultCoverMaxDimension = 512
+
+type getBookCoverInput struct {
+	BookID       int `json:"book_id"`
+	MaxDimension int `json:"max_dimension,omitempty"`
+}
+
+type getBookCoverOutput struct {
+	BookID int            `json:"book_id"`
+	Image  *calibre.Image `json:"image"`
+}
+
+func getBookCover(ctx context.Context, req *mcp.CallToolRequest, input getBookCoverInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*getBookCoverOutput,
+	error,
+) {
+	image, err := calibre.GetBookCover(db, libraryPath, input.BookID, input.MaxDimension)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.ImageContent{Data: image.Data, MIMEType: image.MIMEType},
+		},
+	}, &getBookCoverOutput{BookID: input.BookID, Image: image}, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type findDuplicatesInput struct {
+	Similarity float64 `json:"similarity,omitempty"`
+}
+
+type findDuplicatesOutput struct {
+	Groups []calibre.DuplicateGroup `json:"groups"`
+}
+
+func findDuplicates(ctx context.Context, req *mcp.CallToolRequest, input findDuplicatesInput, db *calibre.DB) (
+	*mcp.CallToolResult,
+	*findDuplicatesOutput,
+	error,
+) {
+	if input.Similarity < 0 || input.Similarity > 1 {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: "similarity must be between 0 and 1"},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	groups, err := calibre.FindDuplicates(ctx, db, input.Similarity)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("%d groups of duplicates found", len(groups)))
+	for i, group := range groups {
+		contentLines = append(contentLines, "")
+		header := fmt.Sprintf("%d. Grouped by %s", i+1, strings.Join(group.Reasons, ", "))
+		if len(group.Keys) > 0 {
+			header += fmt.Sprintf(" (%s)", strings.Join(group.Keys, ", "))
+		}
+		contentLines = append(contentLines, header)
+		for _, book := range group.Books {
+			formats := make([]string, len(book.Formats))
+			for j, f := range book.Formats {
+				formats[j] = fmt.Sprintf("%s %d bytes", f.Format, f.Size)
+			}
+			if len(formats) == 0 {
+				formats = append(formats, "no formats")
+			}
+			contentLines = append(contentLines, fmt.Sprintf("   - %s by %s (ID: %d)", book.Title, strings.Join(book.Authors, ", "), book.ID))
+			contentLines = append(contentLines, fmt.Sprintf("     Formats: %s", strings.Join(formats, ", ")))
+			contentLines = append(contentLines, fmt.Sprintf("     Published: %s, added: %s, modified: %s", book.PubDate, book.Timestamp, book.LastModified))
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &findDuplicatesOutput{Groups: groups}, nil
+}
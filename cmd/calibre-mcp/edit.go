@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type updateBookInput struct {
+	ID int `json:"id"`
+	calibre.BookUpdate
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type updateBookOutput struct {
+	DryRun   bool                `json:"dry_run"`
+	ChangeID int                 `json:"change_id,omitempty"`
+	Changes  *calibre.BookChange `json:"changes"`
+}
+
+func updateBook(ctx context.Context, req *mcp.CallToolRequest, input updateBookInput, db *calibre.DB) (
+	*mcp.CallToolResult,
+	*updateBookOutput,
+	error,
+) {
+	fields := input.BookUpdate.Fields()
+	if len(fields) == 0 {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: "no fields to update"},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	set, err := calibre.EditBooks(ctx, db, []calibre.BookEdit{{BookID: input.ID, Fields: fields}}, input.DryRun)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+	if !input.DryRun && len(set.Changes) > 0 {
+		calibre.ClearCaches()
+		clearBooksSearchCache()
+	}
+
+	change := &calibre.BookChange{BookID: input.ID, Changes: []calibre.FieldChange{}}
+	if len(set.Changes) > 0 {
+		change = &set.Changes[0]
+	}
+
+	// Format the display text
+	var contentLines []string
+	switch {
+	case len(change.Changes) == 0:
+		contentLines = append(contentLines, fmt.Sprintf("Book ID %d already has these values, nothing changed", input.ID))
+	case input.DryRun:
+		contentLines = append(contentLines, fmt.Sprintf("Changes that would be made to %s (ID: %d):", change.Title, input.ID))
+	default:
+		contentLines = append(contentLines, fmt.Sprintf("Updated %s (ID: %d), change %d:", change.Title, input.ID, set.ID))
+	}
+	contentLines = append(contentLines, formatFieldChanges(change.Changes, "")...)
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &updateBookOutput{DryRun: input.DryRun, ChangeID: set.ID, Changes: change}, nil
+}
+
+// formatFieldChanges lists changes as "field: old -> new" lines
+func formatFieldChanges(changes []calibre.FieldChange, indent string) []string {
+	lines := make([]string, len(changes))
+	for i, change := range changes {
+		lines[i] = fmt.Sprintf("%s- %s: %s -> %s", indent, change.Field, formatFieldValue(change.Old), formatFieldValue(change.New))
+	}
+	return lines
+}
+
+func formatFieldValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "(none)"
+	case string:
+		if v == "" {
+			return "(none)"
+		}
+		return fmt.Sprintf("%q", v)
+	case []string:
+		if len(v) == 0 {
+			return "(none)"
+		}
+		return fmt.Sprintf("%q", strings.Join(v, ", "))
+	case float64:
+		return fmt.Sprintf("%g", v)
+	}
+	return fmt.Sprint(value)
+}
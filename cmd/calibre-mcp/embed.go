@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type embedMetadataInput struct {
+	ID     int  `json:"id"`
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type embedMetadataOutput struct {
+	Result *calibre.EmbedResult `json:"result"`
+}
+
+func embedMetadata(ctx context.Context, req *mcp.CallToolRequest, input embedMetadataInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*embedMetadataOutput,
+	error,
+) {
+	result, err := calibre.EmbedMetadata(ctx, db, libraryPath, input.ID, input.DryRun)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+	if !result.DryRun {
+		calibre.ClearCaches()
+	}
+
+	// Format the display text
+	var contentLines []string
+	if result.DryRun {
+		contentLines = append(contentLines, fmt.Sprintf("Dry run: the metadata of %s would be replaced with:", result.File))
+	} else if result.Warning != "" {
+		contentLines = append(contentLines, fmt.Sprintf("Embedded the metadata into %s. Warning: %s", result.File, result.Warning))
+	} else {
+		contentLines = append(contentLines, fmt.Sprintf("Embedded the metadata into %s, change %d:", result.File, result.ChangeID))
+	}
+	contentLines = append(contentLines, "")
+	contentLines = append(contentLines, result.Metadata)
+	for _, skipped := range result.Skipped {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, fmt.Sprintf("Not written: %s", skipped))
+	}
+	if result.Original != "" {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, fmt.Sprintf("The original file was kept as the ORIGINAL_EPUB format, in %s", result.Original))
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &embedMetadataOutput{Result: result}, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type getEPUBChapterImagesInput struct {
+	BookID       int `json:"book_id"`
+	ChapterIndex int `json:"chapter_index"`
+}
+
+type getEPUBChapterImagesOutput struct {
+	Images []calibre.ChapterImage `json:"images"`
+}
+
+type getEPUBImageInput struct {
+	BookID       int `json:"book_id"`
+	ChapterIndex int `json:"chapter_index"`
+	ImageIndex   int `json:"image_index"`
+	MaxDimension int `json:"max_dimension,omitempty"`
+}
+
+type getEPUBImageOutput struct {
+	ChapterIndex int            `json:"chapter_index"`
+	ImageIndex   int            `json:"image_index"`
+	Image        *calibre.Image `json:"image"`
+}
+
+func getEPUBChapterImages(ctx context.Context, req *mcp.CallToolRequest, input getEPUBChapterImagesInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*getEPUBChapterImagesOutput,
+	error,
+) {
+	images, err := calibre.GetEPUBChapterImages(db, libraryPath, input.BookID, input.ChapterIndex)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("Images in chapter %d of book ID %d:", input.ChapterIndex, input.BookID))
+	contentLines = append(contentLines, "")
+	if len(images) == 0 {
+		contentLines = append(contentLines, "No images found.")
+	}
+	for _, image := range images {
+		contentLines = append(contentLines, fmt.Sprintf("%d. %s (%s)", image.Index, image.Label(), image.MediaType))
+		if image.Alt != "" && image.Caption != "" {
+			contentLines = append(contentLines, fmt.Sprintf("   Alt: %s", image.Alt))
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &getEPUBChapterImagesOutput{Images: images}, nil
+}
+
+func getEPUBImage(ctx context.Context, req *mcp.CallToolRequest, input getEPUBImageInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*getEPUBImageOutput,
+	error,
+) {
+	image, err := calibre.GetEPUBImage(db, libraryPath, input.BookID, input.ChapterIndex, input.ImageIndex, input.MaxDimension)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.ImageContent{Data: image.Data, MIMEType: image.MIMEType},
+		},
+	}, &getEPUBImageOutput{ChapterIndex: input.ChapterIndex, ImageIndex: input.ImageIndex, Image: image}, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type undoLastChangeInput struct{}
+
+type revertChangeInput struct {
+	ChangeID int `json:"change_id"`
+}
+
+type revertChangeOutput struct {
+	Change *calibre.ChangeSet `json:"change"`
+}
+
+func undoLastChange(ctx context.Context, req *mcp.CallToolRequest, input undoLastChangeInput, db *calibre.DB) (
+	*mcp.CallToolResult,
+	*revertChangeOutput,
+	error,
+) {
+	set, err := calibre.UndoLastChange(ctx, db)
+	return revertResult(set, err)
+}
+
+func revertChange(ctx context.Context, req *mcp.CallToolRequest, input revertChangeInput, db *calibre.DB) (
+	*mcp.CallToolResult,
+	*revertChangeOutput,
+	error,
+) {
+	set, err := calibre.RevertChange(ctx, db, input.ChangeID)
+	return revertResult(set, err)
+}
+
+func revertResult(set *calibre.ChangeSet, err error) (
+	*mcp.CallToolResult,
+	*revertChangeOutput,
+	error,
+) {
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+	calibre.ClearCaches()
+	clearBooksSearchCache()
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("Reverted change %d with change %d:", set.RevertOf, set.ID))
+	for _, change := range set.Changes {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, fmt.Sprintf("%s (ID: %d)", change.Title, change.BookID))
+		contentLines = append(contentLines, formatFieldChanges(change.Changes, "  ")...)
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &revertChangeOutput{Change: set}, nil
+}
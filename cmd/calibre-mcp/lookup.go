@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// maxCandidates is how many matching books find_book offers to choose from
+const maxCandidates = 25
+
+type findBookInput struct {
+	Title  string `json:"title,omitempty"`
+	Author string `json:"author,omitempty"`
+}
+
+type lookupBookInput struct {
+	Identifier   string `json:"identifier,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Author       string `json:"author,omitempty"`
+	IncludeCover bool   `json:"include_cover,omitempty"`
+}
+
+type findBookOutput struct {
+	Book       *calibre.BookDetails    `json:"book,omitempty"`
+	Candidates []calibre.BookCandidate `json:"candidates,omitempty"`
+}
+
+// candidateLabel describes a candidate in a single line, so that editions of
+// the same title can be told apart
+func candidateLabel(c calibre.BookCandidate) string {
+	label := fmt.Sprintf("%s by %s", c.Title, strings.Join(c.Authors, ", "))
+	if c.Year != "" {
+		label += fmt.Sprintf(" (%s)", c.Year)
+	}
+	if len(c.Formats) > 0 {
+		label += fmt.Sprintf(" [%s]", strings.Join(c.Formats, ", "))
+	}
+	return fmt.Sprintf("ID %d: %s", c.ID, label)
+}
+
+// chooseCandidate asks the user to pick one of the candidates through MCP
+// elicitation, offering at most maxCandidates of them. It returns false when
+// the client doesn't support elicitation or the user made no choice.
+func chooseCandidate(ctx context.Context, session *mcp.ServerSession, input findBookInput, candidates []calibre.BookCandidate) (int, bool, error) {
+	if params := session.InitializeParams(); params == nil || params.Capabilities == nil || params.Capabilities.Elicitation == nil {
+		return 0, false, nil
+	}
+
+	message := fmt.Sprintf("%d books match %q. Which one do you mean?", len(candidates),
+		strings.TrimSpace(strings.Join([]string{input.Title, input.Author}, " ")))
+	if len(candidates) > maxCandidates {
+		message += fmt.Sprintf(" Only the first %d are listed.", maxCandidates)
+		candidates = candidates[:maxCandidates]
+	}
+	ids := make([]string, len(candidates))
+	labels := make([]string, len(candidates))
+	for i, c := range candidates {
+		ids[i] = strconv.Itoa(c.ID)
+		labels[i] = candidateLabel(c)
+	}
+
+	res, err := session.Elicit(ctx, &mcp.ElicitParams{
+		Message: message,
+		RequestedSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"book_id": map[string]any{
+					"type":      "string",
+					"title":     "Book",
+					"enum":      ids,
+					"enumNames": labels,
+				},
+			},
+			"required": []string{"book_id"},
+		},
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if res.Action != "accept" {
+		return 0, false, nil
+	}
+
+	switch v := res.Content["book_id"].(type) {
+	case string:
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid book ID %q", v)
+		}
+		return id, true, nil
+	case float64:
+		return int(v), true, nil
+	}
+	return 0, false, errors.New("the client returned no book ID")
+}
+
+func findBook(ctx context.Context, req *mcp.CallToolRequest, input findBookInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*findBookOutput,
+	error,
+) {
+	candidates, err := func() ([]calibre.BookCandidate, error) {
+		if strings.TrimSpace(input.Title) == "" && strings.TrimSpace(input.Author) == "" {
+			return nil, errors.New("title or author is required")
+		}
+		candidates, err := calibre.FindBooks(ctx, db, input.Title, input.Author)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) == 0 {
+			return nil, errors.New("no book found")
+		}
+		return candidates, nil
+	}()
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	bookID := candidates[0].ID
+	if len(candidates) > 1 {
+		id, ok, err := chooseCandidate(ctx, req.Session, input, candidates)
+		if err != nil {
+			log.Printf("Elicitation failed: %v", err)
+		}
+		if !ok {
+			// Let the model ask the user instead
+			var contentLines []string
+			contentLines = append(contentLines, fmt.Sprintf("%d books match. Ask which one is meant, then call get_book with its ID:", len(candidates)))
+			contentLines = append(contentLines, "")
+			for _, c := range candidates[:min(len(candidates), maxCandidates)] {
+				contentLines = append(contentLines, "- "+candidateLabel(c))
+			}
+			if len(candidates) > maxCandidates {
+				contentLines = append(contentLines, fmt.Sprintf("- … and %d more. Give a more precise title or author to narrow them down.", len(candidates)-maxCandidates))
+				candidates = candidates[:maxCandidates]
+			}
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{
+					&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+				},
+			}, &findBookOutput{Candidates: candidates}, nil
+		}
+		bookID = id
+	}
+
+	res, book, err := getBook(ctx, req, getBookInput{ID: bookID}, db, libraryPath)
+	if book == nil {
+		return res, nil, err
+	}
+	return res, &findBookOutput{Book: book}, err
+}
+
+func lookupBook(ctx context.Context, req *mcp.CallToolRequest, input lookupBookInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*calibre.BookDetails,
+	error,
+) {
+	bookID, err := func() (int, error) {
+		var ids []int
+		var err error
+		switch {
+		case strings.TrimSpace(input.Identifier) != "":
+			ids, err = calibre.FindBooksByIdentifier(ctx, db, input.Identifier)
+		case strings.TrimSpace(input.Title) != "":
+			ids, err = calibre.FindBooksByTitle(ctx, db, input.Title, input.Author)
+		default:
+			return 0, errors.New("identifier or title is required")
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch len(ids) {
+		case 0:
+			return 0, errors.New("no book found")
+		case 1:
+			return ids[0], nil
+		}
+		idList := make([]string, len(ids))
+		for i, id := range ids {
+			idList[i] = strconv.Itoa(id)
+		}
+		return 0, fmt.Errorf("%d books match, with IDs %s", len(ids), strings.Join(idList, ", "))
+	}()
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	return getBook(ctx, req, getBookInput{ID: bookID, IncludeCover: input.IncludeCover}, db, libraryPath)
+}
@@ -7,28 +7,86 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/benoute/calibre-mcp/pkg/calibre"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 	"github.com/rs/cors"
 )
 
-func parseFlags() (transport string, port string, libraryPath string) {
-	flag.StringVar(&transport, "transport", "stdio", "Transport mode: stdio or http")
-	flag.StringVar(&port, "port", "8080", "Port to listen on for http mode")
-	flag.StringVar(&libraryPath, "library-path", ".", "Path to the Calibre library directory")
+type config struct {
+	transport       string
+	port            string
+	libraryPath     string
+	watchInterval   time.Duration
+	cacheDir        string
+	readColumn      string
+	allowWrites     bool
+	journalDir      string
+	backupRetention int
+	inbox           string
+}
+
+func parseFlags() config {
+	var cfg config
+	flag.StringVar(&cfg.transport, "transport", "stdio", "Transport mode: stdio or http")
+	flag.StringVar(&cfg.port, "port", "8080", "Port to listen on for http mode")
+	flag.StringVar(&cfg.libraryPath, "library-path", ".", "Path to the Calibre library directory")
+	flag.DurationVar(&cfg.watchInterval, "watch-interval", 5*time.Second,
+		"How often to poll the library for changes made by other programs (0 disables)")
+	flag.StringVar(&cfg.cacheDir, "cache-dir", defaultCacheDir(), "Directory for the summary cache (empty disables caching)")
+	flag.StringVar(&cfg.readColumn, "read-column", "read",
+		"Lookup name of the custom column holding the read state of books, such as read for #read")
+	flag.BoolVar(&cfg.allowWrites, "allow-writes", false, "Enable the tools that change metadata.db")
+	flag.StringVar(&cfg.journalDir, "journal-dir", "",
+		"Directory for the journal of changes and the metadata.db backups (defaults to .calibre-mcp in the library)")
+	flag.IntVar(&cfg.backupRetention, "backup-retention", 10, "Number of metadata.db backups to keep (0 keeps all)")
+	flag.StringVar(&cfg.inbox, "inbox", "", "Directory of the files that add_book can add to the library (empty disables it)")
 	flag.Parse()
 
-	return transport, port, libraryPath
+	return cfg
+}
+
+// openJournal opens the journal of changes, kept in the library unless
+// -journal-dir is set
+func openJournal(cfg config) (*calibre.Journal, error) {
+	journalDir := cfg.journalDir
+	if journalDir == "" {
+		journalDir = filepath.Join(cfg.libraryPath, ".calibre-mcp")
+	}
+	return calibre.OpenJournal(journalDir, cfg.backupRetention)
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "calibre-mcp")
 }
 
 func main() {
-	transport, port, libraryPath := parseFlags()
+	cfg := parseFlags()
+	port := cfg.port
+
+	// Run a subcommand instead of the server
+	switch flag.Arg(0) {
+	case "check":
+		os.Exit(runCheck(cfg, flag.Args()[1:]))
+	case "add":
+		os.Exit(runAdd(cfg, flag.Args()[1:]))
+	case "":
+	default:
+		log.Fatalf("unknown command %q", flag.Arg(0))
+	}
 
 	// Create a server with search and book retrieval tools
-	server := setupMCPServer(libraryPath)
+	server := setupMCPServer(cfg)
 
 	// Run the server based on transport
-	switch transport {
+	switch cfg.transport {
 	case "http":
 		fmt.Printf("Server running in HTTP mode on port %s\n", port)
 		handler := mcp.NewStreamableHTTPHandler(
@@ -2,11 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/google/jsonschema-go/jsonschema"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
@@ -21,7 +28,8 @@ type searchBooksOutput struct {
 }
 
 type getBookInput struct {
-	ID int `json:"id"`
+	ID           int  `json:"id"`
+	IncludeCover bool `json:"include_cover,omitempty"`
 }
 
 type getEPUBChaptersInput struct {
@@ -57,7 +65,17 @@ type booksSearchCacheEntry struct {
 	timestamp time.Time
 }
 
-var booksSearchCache = make(map[string]booksSearchCacheEntry)
+var (
+	booksSearchCache   = make(map[string]booksSearchCacheEntry)
+	booksSearchCacheMu sync.Mutex
+)
+
+// clearBooksSearchCache drops cached search results, for use when the library changes
+func clearBooksSearchCache() {
+	booksSearchCacheMu.Lock()
+	defer booksSearchCacheMu.Unlock()
+	clear(booksSearchCache)
+}
 
 func searchBooks(ctx context.Context, req *mcp.CallToolRequest, input searchBooksInput, db *calibre.DB) (
 	*mcp.CallToolResult,
@@ -66,7 +84,10 @@ func searchBooks(ctx context.Context, req *mcp.CallToolRequest, input searchBook
 ) {
 	key := input.Query
 	var results *calibre.SearchResult
-	if entry, ok := booksSearchCache[key]; ok && time.Since(entry.timestamp) < time.Minute {
+	booksSearchCacheMu.Lock()
+	entry, ok := booksSearchCache[key]
+	booksSearchCacheMu.Unlock()
+	if ok && time.Since(entry.timestamp) < time.Minute {
 		results = entry.results
 	} else {
 		var err error
@@ -79,7 +100,9 @@ func searchBooks(ctx context.Context, req *mcp.CallToolRequest, input searchBook
 				IsError: true,
 			}, nil, nil
 		}
+		booksSearchCacheMu.Lock()
 		booksSearchCache[key] = booksSearchCacheEntry{results: results, timestamp: time.Now()}
+		booksSearchCacheMu.Unlock()
 	}
 
 	// Apply limit and offset
@@ -131,7 +154,7 @@ func searchBooks(ctx context.Context, req *mcp.CallToolRequest, input searchBook
 	}, &searchBooksOutput, nil
 }
 
-func getBook(ctx context.Context, req *mcp.CallToolRequest, input getBookInput, db *calibre.DB) (
+func getBook(ctx context.Context, req *mcp.CallToolRequest, input getBookInput, db *calibre.DB, libraryPath string) (
 	*mcp.CallToolResult,
 	*calibre.BookDetails,
 	error,
@@ -151,13 +174,17 @@ func getBook(ctx context.Context, req *mcp.CallToolRequest, input getBookInput,
 	contentLines = append(contentLines, fmt.Sprintf("# %s", book.Title))
 	contentLines = append(contentLines, "")
 	contentLines = append(contentLines, fmt.Sprintf("**Authors:** %s", strings.Join(book.Authors, ", ")))
+	contentLines = append(contentLines, formatNotes(book.Notes, "authors")...)
 	if len(book.Tags) > 0 {
 		contentLines = append(contentLines, fmt.Sprintf("**Tags:** %s", strings.Join(book.Tags, ", ")))
+		contentLines = append(contentLines, formatNotes(book.Notes, "tags")...)
 	}
 	if book.Series != "" {
 		contentLines = append(contentLines, fmt.Sprintf("**Series:** %s #%g", book.Series, book.SeriesIndex))
+		contentLines = append(contentLines, formatNotes(book.Notes, "series")...)
 	}
 	contentLines = append(contentLines, fmt.Sprintf("**Publisher:** %s", book.Publisher))
+	contentLines = append(contentLines, formatNotes(book.Notes, "publishers")...)
 	contentLines = append(contentLines, fmt.Sprintf("**Publication Date:** %s", book.PubDate))
 	if book.Isbn != "" {
 		contentLines = append(contentLines, fmt.Sprintf("**ISBN:** %s", book.Isbn))
@@ -168,16 +195,29 @@ func getBook(ctx context.Context, req *mcp.CallToolRequest, input getBookInput,
 		contentLines = append(contentLines, fmt.Sprintf("**Rating:** %d/5", book.Rating))
 	}
 	contentLines = append(contentLines, fmt.Sprintf("**Formats:** %s", strings.Join(book.Formats, ", ")))
+	for _, position := range book.ReadingPositions {
+		contentLines = append(contentLines, fmt.Sprintf("**Reading progress:** %s", formatReadingPosition(position)))
+	}
 	if book.Comments != "" {
 		contentLines = append(contentLines, "")
 		contentLines = append(contentLines, "**Comments:**")
 		contentLines = append(contentLines, book.Comments)
 	}
 
+	content := []mcp.Content{
+		&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+	}
+	if input.IncludeCover {
+		cover, err := calibre.GetBookCover(db, libraryPath, input.ID, defaultCoverMaxDimension)
+		if err != nil {
+			content = append(content, &mcp.TextContent{Text: err.Error()})
+		} else {
+			content = append(content, &mcp.ImageContent{Data: cover.Data, MIMEType: cover.MIMEType})
+		}
+	}
+
 	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
-		},
+		Content: content,
 	}, book, nil
 }
 
@@ -260,8 +300,10 @@ func searchEPUBContent(ctx context.Context, req *mcp.CallToolRequest, input sear
 		contentLines = append(contentLines, "No matches found.")
 	} else {
 		for _, match := range matches {
-			contentLines = append(contentLines, fmt.Sprintf("Chapter %d: %s", match.ChapterIndex, match.ChapterTitle))
+			contentLines = append(contentLines, fmt.Sprintf("Chapter %d: %s, offset %d", match.ChapterIndex, match.ChapterTitle, match.Offset))
 			contentLines = append(contentLines, fmt.Sprintf("  %s", match.Snippet))
+			contentLines = append(contentLines, fmt.Sprintf("  CFI: %s", match.CFI))
+			contentLines = append(contentLines, fmt.Sprintf("  Paragraph CFI: %s", match.ParagraphCFI))
 			contentLines = append(contentLines, "")
 		}
 	}
@@ -277,18 +319,123 @@ func searchEPUBContent(ctx context.Context, req *mcp.CallToolRequest, input sear
 	}, &searchEPUBContentOutput, nil
 }
 
+// addTool registers a tool like mcp.AddTool, and is used for every tool of
+// the server. It differs from mcp.AddTool in two ways that clients see:
+//   - book ID arguments (see bookIDArguments) take an integer or a string, so
+//     that a book UUID can be given instead of the ID. resolveBookUUIDs
+//     replaces the UUIDs with IDs before the arguments are validated.
+//   - error results of the handler are returned to the SDK as errors. Clients
+//     still get an error result with the same text, but without the zero
+//     output, which would fail validation against the output schema.
+func addTool[In, Out any](server *mcp.Server, tool *mcp.Tool, handler mcp.ToolHandlerFor[In, Out]) {
+	schema, err := jsonschema.For[In](&jsonschema.ForOptions{})
+	if err != nil {
+		panic(fmt.Sprintf("tool %q: %v", tool.Name, err))
+	}
+	for name, prop := range schema.Properties {
+		if bookIDArguments[name] && prop.Type == "integer" {
+			prop.Type = ""
+			prop.Types = []string{"integer", "string"}
+			prop.Description = "Book ID, or book UUID"
+		}
+	}
+	tool.InputSchema = schema
+
+	// The SDK validates the zero output of failed calls against the output
+	// schema, so tool errors are returned as errors, which it reports as error
+	// results without output
+	mcp.AddTool(server, tool, func(ctx context.Context, req *mcp.CallToolRequest, input In) (*mcp.CallToolResult, Out, error) {
+		res, out, err := handler(ctx, req, input)
+		if err == nil && res != nil && res.IsError {
+			var messages []string
+			for _, content := range res.Content {
+				if text, ok := content.(*mcp.TextContent); ok {
+					messages = append(messages, text.Text)
+				}
+			}
+			err = errors.New(strings.Join(messages, "\n"))
+		}
+		return res, out, err
+	})
+}
+
+// resolveBookUUIDs returns a middleware that replaces the book UUIDs passed as
+// tool arguments in place of book IDs with the matching IDs
+func resolveBookUUIDs(db *calibre.DB) mcp.Middleware {
+	return func(next mcp.MethodHandler) mcp.MethodHandler {
+		return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+			call, ok := req.(*mcp.CallToolRequest)
+			if !ok || len(call.Params.Arguments) == 0 {
+				return next(ctx, method, req)
+			}
+
+			var args map[string]any
+			if err := json.Unmarshal(call.Params.Arguments, &args); err != nil {
+				return next(ctx, method, req)
+			}
+			changed := false
+			for name, value := range args {
+				ref, ok := value.(string)
+				if !ok || !bookIDArguments[name] {
+					continue
+				}
+				id, err := strconv.Atoi(strings.TrimSpace(ref))
+				if err != nil {
+					id, err = calibre.GetBookIDByUUID(ctx, db, ref)
+					if err != nil {
+						return &mcp.CallToolResult{
+							Content: []mcp.Content{
+								&mcp.TextContent{Text: err.Error()},
+							},
+							IsError: true,
+						}, nil
+					}
+				}
+				args[name] = id
+				changed = true
+			}
+			if changed {
+				data, err := json.Marshal(args)
+				if err != nil {
+					return nil, err
+				}
+				call.Params.Arguments = data
+			}
+			return next(ctx, method, req)
+		}
+	}
+}
+
 // setupMCPServer creates and configures the MCP server with Calibre tools
-func setupMCPServer(libraryPath string) *mcp.Server {
+func setupMCPServer(cfg config) *mcp.Server {
+	libraryPath := cfg.libraryPath
 	db, err := calibre.OpenLibrary(libraryPath)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to open Calibre library: %v", err))
 	}
 
+	var summaryCache *calibre.SummaryCache
+	if cfg.cacheDir != "" {
+		summaryCache, err = calibre.OpenSummaryCache(filepath.Join(cfg.cacheDir, "summaries.db"), libraryPath)
+		if err != nil {
+			log.Printf("Summary cache disabled: %v", err)
+		}
+	}
+
+	subscriptions := newSubscriptions()
+
 	// Create a server with search and book retrieval tools
-	server := mcp.NewServer(&mcp.Implementation{Name: "calibre-mcp", Version: "v1.1.0"}, nil)
+	server := mcp.NewServer(&mcp.Implementation{Name: "calibre-mcp", Version: "v1.1.0"}, &mcp.ServerOptions{
+		SubscribeHandler:   subscriptions.subscribe,
+		UnsubscribeHandler: subscriptions.unsubscribe,
+		CompletionHandler: func(ctx context.Context, req *mcp.CompleteRequest) (*mcp.CompleteResult, error) {
+			return complete(ctx, req, db)
+		},
+	})
+	server.AddReceivingMiddleware(resolveBookUUIDs(db))
 
 	// Add search tool
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name: "search_books",
 		Description: "Search for books in the Calibre library by title, author, tags, or other metadata. " +
 			"Returns a list of matching books with basic information. Supports limit and offset for fast pagination through results.",
@@ -298,18 +445,151 @@ func setupMCPServer(libraryPath string) *mcp.Server {
 		return searchBooks(ctx, req, input, db)
 	})
 
+	// Add browse category tool
+	addTool(server, &mcp.Tool{
+		Name: "browse_category",
+		Description: "List the authors, tags, series, publishers, languages or formats of the library with their book counts. " +
+			"Authors include their sort name and link, and items with a note in Calibre an excerpt of it. Sort by " +
+			"name (default) or count, filter by a name prefix, and paginate with limit (default 100) and offset. " +
+			"Use get_category_books to list the books of one item.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input browseCategoryInput) (
+		*mcp.CallToolResult, *browseCategoryOutput, error,
+	) {
+		return browseCategory(ctx, req, input, db)
+	})
+
+	// Add get category books tool
+	addTool(server, &mcp.Tool{
+		Name: "get_category_books",
+		Description: "List the books of one author, tag, series, publisher, language or format, sorted by title, " +
+			"after the note Calibre keeps on the item if any. Supports limit (default 100) and offset for pagination.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input getCategoryBooksInput) (
+		*mcp.CallToolResult, *categoryBooksOutput, error,
+	) {
+		return getCategoryBooks(ctx, req, input, db)
+	})
+
+	// Add search notes tool
+	addTool(server, &mcp.Tool{
+		Name: "search_notes",
+		Description: "Search the notes Calibre 7 keeps on authors, tags, series and publishers for words, ignoring " +
+			"case and accents. Returns the most recently modified notes first, with the names of their linked " +
+			"resources. Supports limit and offset for pagination.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input searchNotesInput) (
+		*mcp.CallToolResult, *searchNotesOutput, error,
+	) {
+		return searchNotes(ctx, req, input, db)
+	})
+
+	// Add get series tool
+	addTool(server, &mcp.Tool{
+		Name: "get_series",
+		Description: "List the owned volumes of a series in reading order, marked read or unread from the read custom column. " +
+			"Reports the missing volumes, the indices held by several books, and the next volume to read.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input getSeriesInput) (
+		*mcp.CallToolResult, *getSeriesOutput, error,
+	) {
+		return getSeries(ctx, req, input, db, cfg.readColumn)
+	})
+
+	// Add library stats tool
+	addTool(server, &mcp.Tool{
+		Name: "library_stats",
+		Description: "Report statistics over the library: totals, books added this year, distributions by language, format, " +
+			"tag, author, publication decade and added month, a rating histogram, the average rating per tag, storage by " +
+			"format, the largest books and the books with no formats. Set query to narrow the report to the books " +
+			"matching a search_books query.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input libraryStatsInput) (
+		*mcp.CallToolResult, *libraryStatsOutput, error,
+	) {
+		return libraryStats(ctx, req, input, db)
+	})
+
+	// Add check library tool
+	addTool(server, &mcp.Tool{
+		Name: "check_library",
+		Description: "Check the library for problems: SQLite integrity, missing book folders and format files, file sizes " +
+			"that differ from the recorded ones, covers that don't match has_cover, and orphan folders that no book refers to.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input checkLibraryInput) (
+		*mcp.CallToolResult, *checkLibraryOutput, error,
+	) {
+		return checkLibrary(ctx, req, input, db, libraryPath)
+	})
+
+	// Add compare metadata tool
+	addTool(server, &mcp.Tool{
+		Name: "compare_metadata",
+		Description: "Compare the metadata embedded in the OPF of EPUB files with the library, for one book by id or " +
+			"for every book with an EPUB, to find stale or mis-tagged files. Reports the fields set in a file to " +
+			"another value than in the library: title, authors, tags, series and series_index, rating, publisher, " +
+			"pubdate, languages and identifiers.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input compareMetadataInput) (
+		*mcp.CallToolResult, *compareMetadataOutput, error,
+	) {
+		return compareMetadata(ctx, req, input, db, libraryPath)
+	})
+
+	// Add find duplicates tool
+	addTool(server, &mcp.Tool{
+		Name: "find_duplicates",
+		Description: "Find groups of books that are likely duplicates: same title and authors ignoring case, accents and " +
+			"punctuation, or a shared ISBN or other identifier. Set similarity (from 0 to 1, such as 0.85) to also group " +
+			"books by a same author with similar titles. Each book is listed with its formats, sizes and dates.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input findDuplicatesInput) (
+		*mcp.CallToolResult, *findDuplicatesOutput, error,
+	) {
+		return findDuplicates(ctx, req, input, db)
+	})
+
 	// Add get book tool
-	mcp.AddTool(server, &mcp.Tool{
-		Name:        "get_book",
-		Description: "Retrieve detailed information about a specific book by its ID from the Calibre library",
+	addTool(server, &mcp.Tool{
+		Name: "get_book",
+		Description: "Retrieve detailed information about a specific book by its ID from the Calibre library, " +
+			"with the notes Calibre keeps on its authors, series, publisher and tags. Set include_cover to also return the book cover as an image.",
 	}, func(ctx context.Context, req *mcp.CallToolRequest, input getBookInput) (
 		*mcp.CallToolResult, *calibre.BookDetails, error,
 	) {
-		return getBook(ctx, req, input, db)
+		return getBook(ctx, req, input, db, libraryPath)
+	})
+
+	// Add find book tool
+	addTool(server, &mcp.Tool{
+		Name: "find_book",
+		Description: "Find a book by title and/or author, ignoring case and accents. " +
+			"When several books match, the user is asked to choose one if the client supports elicitation; " +
+			"otherwise the candidates are returned with their ID, authors, year and formats.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input findBookInput) (
+		*mcp.CallToolResult, *findBookOutput, error,
+	) {
+		return findBook(ctx, req, input, db, libraryPath)
+	})
+
+	// Add lookup book tool
+	addTool(server, &mcp.Tool{
+		Name: "lookup_book",
+		Description: "Look up a book by identifier or by exact title and author, and return its details. " +
+			"Identifiers are written as type:value, such as isbn:9780441013593, goodreads:234225, amazon:B00B7NPRY8 " +
+			"or uuid:<book UUID>. ISBN-10 and ISBN-13 forms match each other. Unlike book IDs, identifiers and UUIDs " +
+			"are the same in every copy of a library.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input lookupBookInput) (
+		*mcp.CallToolResult, *calibre.BookDetails, error,
+	) {
+		return lookupBook(ctx, req, input, db, libraryPath)
+	})
+
+	// Add get book cover tool
+	addTool(server, &mcp.Tool{
+		Name: "get_book_cover",
+		Description: "Get the cover of a book from the Calibre library as a JPEG image, at most 1024 pixels wide or high by default. " +
+			"Use max_dimension to downscale the cover further and keep the payload small.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input getBookCoverInput) (
+		*mcp.CallToolResult, *getBookCoverOutput, error,
+	) {
+		return getBookCover(ctx, req, input, db, libraryPath)
 	})
 
 	// Add get EPUB chapters tool
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name:        "get_epub_chapters",
 		Description: "Get the list of chapters in an EPUB book from the Calibre library by its ID",
 	}, func(ctx context.Context, req *mcp.CallToolRequest, input getEPUBChaptersInput) (
@@ -319,25 +599,253 @@ func setupMCPServer(libraryPath string) *mcp.Server {
 	})
 
 	// Add get EPUB chapter content tool
-	mcp.AddTool(server, &mcp.Tool{
-		Name:        "get_epub_chapter_content",
-		Description: "Get the text content of a specific chapter in an EPUB book from the Calibre library",
+	addTool(server, &mcp.Tool{
+		Name: "get_epub_chapter_content",
+		Description: "Get the text content of a specific chapter in an EPUB book from the Calibre library. " +
+			"Paragraphs are on separate lines and images are replaced with [Figure N] placeholders, which give the alt text of images without a caption; the " +
+			"chapter offsets of the other EPUB tools count the characters of this text.",
 	}, func(ctx context.Context, req *mcp.CallToolRequest, input getEPUBChapterContentInput) (
 		*mcp.CallToolResult, *getEPUBChapterContentOutput, error,
 	) {
 		return getEPUBChapterContent(ctx, req, input, db, libraryPath)
 	})
 
+	// Add get EPUB chapter images tool
+	addTool(server, &mcp.Tool{
+		Name: "get_epub_chapter_images",
+		Description: "List the images and figures of a chapter in an EPUB book from the Calibre library, " +
+			"with their alt text, caption and media type. Chapter content marks each image with a " +
+			"[Figure N] placeholder, which is image index N-1.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input getEPUBChapterImagesInput) (
+		*mcp.CallToolResult, *getEPUBChapterImagesOutput, error,
+	) {
+		return getEPUBChapterImages(ctx, req, input, db, libraryPath)
+	})
+
+	// Add get EPUB image tool
+	addTool(server, &mcp.Tool{
+		Name: "get_epub_image",
+		Description: "Get an image from a chapter of an EPUB book from the Calibre library. " +
+			"Use max_dimension to downscale large images.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input getEPUBImageInput) (
+		*mcp.CallToolResult, *getEPUBImageOutput, error,
+	) {
+		return getEPUBImage(ctx, req, input, db, libraryPath)
+	})
+
 	// Add search EPUB content tool
-	mcp.AddTool(server, &mcp.Tool{
+	addTool(server, &mcp.Tool{
 		Name: "search_epub_content",
 		Description: "Search for text within the content of an EPUB book from the Calibre " +
-			"library and return matching paragraphs with chapter information. Supports limit and offset for fast pagination - use offset to walk through results.",
+			"library and return matching paragraphs with chapter information, the offset of the match in the chapter " +
+			"text, and EPUB CFIs of the match and the paragraph for get_passage_by_cfi or Calibre's viewer. " +
+			"Supports limit and offset for fast pagination - use offset to walk through results.",
 	}, func(ctx context.Context, req *mcp.CallToolRequest, input searchEPUBContentInput) (
 		*mcp.CallToolResult, *searchEPUBContentOutput, error,
 	) {
 		return searchEPUBContent(ctx, req, input, db, libraryPath)
 	})
 
+	// Add get passage by CFI tool
+	addTool(server, &mcp.Tool{
+		Name: "get_passage_by_cfi",
+		Description: "Get the text an EPUB CFI points to in a book: the text of a range CFI, such as the ones of " +
+			"search_epub_content, or up to length characters (1000 by default) from a position such as a Calibre " +
+			"bookmark. Accepts the CFIs of the EPUB specification and of Calibre's viewer; highlight CFIs, which " +
+			"are relative to a chapter, also need chapter_index.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input getPassageByCFIInput) (
+		*mcp.CallToolResult, *getPassageByCFIOutput, error,
+	) {
+		return getPassageByCFI(ctx, req, input, db, libraryPath)
+	})
+
+	// Add currently reading tool
+	addTool(server, &mcp.Tool{
+		Name: "currently_reading",
+		Description: "List the books started but not finished in Calibre's viewer, most recently read first, with " +
+			"their progress, format and date of last reading. Use limit to only get the most recent ones.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input currentlyReadingInput) (
+		*mcp.CallToolResult, *currentlyReadingOutput, error,
+	) {
+		return currentlyReading(ctx, req, input, db)
+	})
+
+	// Add resume reading tool
+	addTool(server, &mcp.Tool{
+		Name: "resume_reading",
+		Description: "Find where the reading of an EPUB book stopped in Calibre's viewer and return the chapter, the " +
+			"offset in the chapter text and the text that follows, up to length characters (2000 by default).",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input resumeReadingInput) (
+		*mcp.CallToolResult, *resumeReadingOutput, error,
+	) {
+		return resumeReading(ctx, req, input, db, libraryPath)
+	})
+
+	// Add get book annotations tool
+	addTool(server, &mcp.Tool{
+		Name: "get_book_annotations",
+		Description: "List the highlights and bookmarks made in Calibre's viewer for a book, in reading order, with " +
+			"the highlighted text, notes and color. EPUB annotations are located with their chapter index and the " +
+			"surrounding text.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input getBookAnnotationsInput) (
+		*mcp.CallToolResult, *annotationsOutput, error,
+	) {
+		return getBookAnnotations(ctx, req, input, db, libraryPath)
+	})
+
+	// Add search annotations tool
+	addTool(server, &mcp.Tool{
+		Name: "search_annotations",
+		Description: "Search the highlights and bookmarks of all books for words of their text, notes or title, " +
+			"ignoring case and accents. Returns the most recent first, located like get_book_annotations. " +
+			"Supports limit and offset for pagination.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input searchAnnotationsInput) (
+		*mcp.CallToolResult, *annotationsOutput, error,
+	) {
+		return searchAnnotations(ctx, req, input, db, libraryPath)
+	})
+
+	// Add summarize chapter tool
+	addTool(server, &mcp.Tool{
+		Name: "summarize_chapter",
+		Description: "Summarize a chapter of an EPUB book from the Calibre library. The text is sent in chunks " +
+			"to the client's language model through MCP sampling, and summaries are cached until the book changes.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input summarizeChapterInput) (
+		*mcp.CallToolResult, *summarizeOutput, error,
+	) {
+		return summarizeChapter(ctx, req, input, db, libraryPath, summaryCache)
+	})
+
+	// Add summarize book tool
+	addTool(server, &mcp.Tool{
+		Name: "summarize_book",
+		Description: "Summarize a whole EPUB book from the Calibre library by summarizing each chapter and then " +
+			"the chapter summaries, through MCP sampling. Summaries are cached until the book changes.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input summarizeBookInput) (
+		*mcp.CallToolResult, *summarizeOutput, error,
+	) {
+		return summarizeBook(ctx, req, input, db, libraryPath, summaryCache)
+	})
+
+	// Add get comic pages tool
+	addTool(server, &mcp.Tool{
+		Name: "get_comic_pages",
+		Description: "List the pages of a comic book (CBZ, CBR or CB7) from the Calibre library in reading order, " +
+			"along with its ComicInfo.xml metadata (series, number, writer, summary) when present",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input getComicPagesInput) (
+		*mcp.CallToolResult, *getComicPagesOutput, error,
+	) {
+		return getComicPages(ctx, req, input, db, libraryPath)
+	})
+
+	// Add get comic page tool
+	addTool(server, &mcp.Tool{
+		Name: "get_comic_page",
+		Description: "Get a single page of a comic book from the Calibre library as an image. " +
+			"Use max_dimension to downscale large pages.",
+	}, func(ctx context.Context, req *mcp.CallToolRequest, input getComicPageInput) (
+		*mcp.CallToolResult, *getComicPageOutput, error,
+	) {
+		return getComicPage(ctx, req, input, db, libraryPath)
+	})
+
+	// Add metadata editing tools, only when writes are allowed
+	if cfg.allowWrites {
+		journal, err := openJournal(cfg)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to open the journal of changes: %v", err))
+		}
+		db.SetJournal(journal)
+
+		addTool(server, &mcp.Tool{
+			Name: "update_book",
+			Description: "Change the metadata of a book: title, authors, tags, series and series_index, rating (0.5 to 5 " +
+				"stars), publisher, pubdate (YYYY-MM-DD), comments, identifiers (such as isbn) and custom_columns by " +
+				"lookup name. Only the given fields change, and empty values clear them. Lists replace the current ones. " +
+				"Set dry_run to preview the changes. Returns the old and new value of each changed field. Unlike Calibre, " +
+				"it does not rename the book folder and files when the title or first author changes.",
+		}, func(ctx context.Context, req *mcp.CallToolRequest, input updateBookInput) (
+			*mcp.CallToolResult, *updateBookOutput, error,
+		) {
+			return updateBook(ctx, req, input, db)
+		})
+
+		addTool(server, &mcp.Tool{
+			Name: "bulk_edit",
+			Description: "Apply an operation to every book matching a search_books query, or to the whole library when " +
+				"query is empty: add_tag or remove_tag value, rename_tag the tags in from to value, merge_authors the " +
+				"authors in from into value, or set_series to value (empty removes the series). The first call is a dry " +
+				"run returning the before and after values of each affected book and a plan_token; call again with only " +
+				"plan_token to apply exactly these changes in a single transaction. Merging authors does not rename " +
+				"book folders.",
+		}, func(ctx context.Context, req *mcp.CallToolRequest, input bulkEditInput) (
+			*mcp.CallToolResult, *bulkEditOutput, error,
+		) {
+			return bulkEdit(ctx, req, input, db)
+		})
+
+		addTool(server, &mcp.Tool{
+			Name: "undo_last_change",
+			Description: "Undo the most recent change made by update_book or bulk_edit that was not undone yet, " +
+				"restoring the old values recorded in the journal. Fails if the books changed since.",
+		}, func(ctx context.Context, req *mcp.CallToolRequest, input undoLastChangeInput) (
+			*mcp.CallToolResult, *revertChangeOutput, error,
+		) {
+			return undoLastChange(ctx, req, input, db)
+		})
+
+		addTool(server, &mcp.Tool{
+			Name: "revert_change",
+			Description: "Revert a change by the change ID returned by update_book, bulk_edit or a previous revert, " +
+				"restoring the old values recorded in the journal. Fails if the books changed since.",
+		}, func(ctx context.Context, req *mcp.CallToolRequest, input revertChangeInput) (
+			*mcp.CallToolResult, *revertChangeOutput, error,
+		) {
+			return revertChange(ctx, req, input, db)
+		})
+
+		addTool(server, &mcp.Tool{
+			Name: "embed_metadata",
+			Description: "Write the metadata of a book from the library into the OPF of its EPUB file, as Calibre does " +
+				"when saving to disk: title, authors with their sort names, tags as subjects, identifiers, series and " +
+				"cover. The rest of the EPUB is kept, and the first time the original file is kept as the ORIGINAL_EPUB " +
+				"format. Set dry_run to see the metadata elements that would be written.",
+		}, func(ctx context.Context, req *mcp.CallToolRequest, input embedMetadataInput) (
+			*mcp.CallToolResult, *embedMetadataOutput, error,
+		) {
+			return embedMetadata(ctx, req, input, db, libraryPath)
+		})
+
+		if cfg.inbox != "" {
+			addTool(server, &mcp.Tool{
+				Name: "add_book",
+				Description: "Add a file of the inbox directory to the library, given by its path relative to the inbox. " +
+					"Title, authors, languages, identifiers, description and cover are read from EPUB files, and from " +
+					"file names such as \"Title - Author.pdf\" otherwise; title and authors override them. The book is " +
+					"not added when the library seems to have it already, unless allow_duplicate is set. Set dry_run to " +
+					"only see the metadata and the possible duplicates.",
+			}, func(ctx context.Context, req *mcp.CallToolRequest, input addBookInput) (
+				*mcp.CallToolResult, *addBookOutput, error,
+			) {
+				return addBook(ctx, req, input, db, libraryPath, cfg.inbox)
+			})
+		}
+	}
+
+	addResourceTemplates(server, db, libraryPath)
+	addPrompts(server, db, libraryPath)
+
+	if cfg.watchInterval > 0 {
+		watcher := calibre.NewWatcher(db, libraryPath, cfg.watchInterval)
+		go func() {
+			err := watcher.Watch(context.Background(), func(change *calibre.LibraryChange) {
+				handleLibraryChange(context.Background(), server, db, subscriptions, change)
+			})
+			if err != nil {
+				log.Printf("Library watcher stopped: %v", err)
+			}
+		}()
+	}
+
 	return server
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// noteExcerptLength is how many characters of notes are shown in lists
+const noteExcerptLength = 200
+
+type searchNotesInput struct {
+	Query  string `json:"query"`
+	Limit  int    `json:"limit,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+}
+
+type searchNotesOutput struct {
+	Results *calibre.NoteSearchResult `json:"results"`
+}
+
+func searchNotes(ctx context.Context, req *mcp.CallToolRequest, input searchNotesInput, db *calibre.DB) (
+	*mcp.CallToolResult,
+	*searchNotesOutput,
+	error,
+) {
+	results, err := calibre.SearchNotes(ctx, db, input.Query, input.Limit, input.Offset)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("Notes matching '%s':", input.Query))
+	contentLines = append(contentLines, "")
+	for _, note := range results.Notes {
+		contentLines = append(contentLines, fmt.Sprintf("- %s (%s), modified %s", note.Name, note.Category, note.Modified))
+		contentLines = append(contentLines, fmt.Sprintf("  %s", noteExcerpt(note.Text)))
+		if len(note.Resources) > 0 {
+			contentLines = append(contentLines, fmt.Sprintf("  Resources: %s", strings.Join(note.Resources, ", ")))
+		}
+	}
+	contentLines = append(contentLines, "")
+	contentLines = append(contentLines, fmt.Sprintf("Total results: %d", results.TotalNum))
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &searchNotesOutput{Results: results}, nil
+}
+
+// formatNotes returns the lines showing the notes on the items of a category
+func formatNotes(notes []calibre.Note, category string) []string {
+	var lines []string
+	for _, note := range notes {
+		if note.Category == category {
+			lines = append(lines, fmt.Sprintf("**About %s:** %s", note.Name, note.Text))
+		}
+	}
+	return lines
+}
+
+// noteExcerpt returns the start of a note on a single line
+func noteExcerpt(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > noteExcerptLength {
+		return string(runes[:noteExcerptLength]) + "…"
+	}
+	return text
+}
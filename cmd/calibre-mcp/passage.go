@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type getPassageByCFIInput struct {
+	BookID       int    `json:"book_id"`
+	CFI          string `json:"cfi"`
+	ChapterIndex *int   `json:"chapter_index,omitempty"`
+	Length       int    `json:"length,omitempty"`
+}
+
+type getPassageByCFIOutput struct {
+	Passage *calibre.Passage `json:"passage"`
+}
+
+func getPassageByCFI(ctx context.Context, req *mcp.CallToolRequest, input getPassageByCFIInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*getPassageByCFIOutput,
+	error,
+) {
+	chapterIndex := -1
+	if input.ChapterIndex != nil {
+		chapterIndex = *input.ChapterIndex
+	}
+	passage, err := calibre.GetPassageByCFI(db, libraryPath, input.BookID, input.CFI, chapterIndex, input.Length)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("Passage at %s in book ID %d", passage.CFI, passage.BookID))
+	contentLines = append(contentLines, fmt.Sprintf("Chapter %d: %s, offset %d",
+		passage.Location.ChapterIndex, passage.Location.ChapterTitle, passage.Location.Offset))
+	contentLines = append(contentLines, "")
+	contentLines = append(contentLines, passage.Text)
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &getPassageByCFIOutput{Passage: passage}, nil
+}
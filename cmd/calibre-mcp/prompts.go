@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// similarBooksLimit caps the candidates embedded in the similar_books prompt
+const similarBooksLimit = 15
+
+// promptIntArg parses a required integer prompt argument
+func promptIntArg(req *mcp.GetPromptRequest, name string) (int, error) {
+	value := strings.TrimSpace(req.Params.Arguments[name])
+	if value == "" {
+		return 0, fmt.Errorf("missing required argument %q", name)
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("argument %q must be an integer", name)
+	}
+	return n, nil
+}
+
+func textMessage(text string) *mcp.PromptMessage {
+	return &mcp.PromptMessage{
+		Role:    "user",
+		Content: &mcp.TextContent{Text: text},
+	}
+}
+
+// resourceMessage embeds the contents of a resource that the resource
+// templates serve, so that clients can read it again by its URI. Data that no
+// template serves is sent with textMessage instead.
+func resourceMessage(uri string, mimeType string, text string) *mcp.PromptMessage {
+	return &mcp.PromptMessage{
+		Role: "user",
+		Content: &mcp.EmbeddedResource{
+			Resource: &mcp.ResourceContents{URI: uri, MIMEType: mimeType, Text: text},
+		},
+	}
+}
+
+// bookMessage fetches a book and embeds its metadata as a resource
+func bookMessage(ctx context.Context, db *calibre.DB, bookID int) (*mcp.PromptMessage, *calibre.BookDetails, error) {
+	book, err := calibre.GetBook(ctx, db, bookID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("book %d: %w", bookID, err)
+	}
+	data, err := json.Marshal(book)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resourceMessage(bookURI(bookID), "application/json", string(data)), book, nil
+}
+
+// chapterMessage fetches the text of a chapter and embeds it as a resource
+func chapterMessage(db *calibre.DB, libraryPath string, bookID int, chapterIndex int) (*mcp.PromptMessage, error) {
+	content, err := calibre.GetEPUBChapterContent(db, libraryPath, bookID, chapterIndex)
+	if err != nil {
+		return nil, err
+	}
+	uri := fmt.Sprintf("%s/chapter/%d", bookURI(bookID), chapterIndex)
+	return resourceMessage(uri, "text/plain", content), nil
+}
+
+func summarizeChapterPrompt(ctx context.Context, req *mcp.GetPromptRequest, db *calibre.DB, libraryPath string) (*mcp.GetPromptResult, error) {
+	bookID, err := promptIntArg(req, "book_id")
+	if err != nil {
+		return nil, err
+	}
+	chapterIndex, err := promptIntArg(req, "chapter_index")
+	if err != nil {
+		return nil, err
+	}
+
+	bookMsg, book, err := bookMessage(ctx, db, bookID)
+	if err != nil {
+		return nil, err
+	}
+	chapterMsg, err := chapterMessage(db, libraryPath, bookID, chapterIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcp.GetPromptResult{
+		Description: fmt.Sprintf("Summarize chapter %d of %s", chapterIndex, book.Title),
+		Messages: []*mcp.PromptMessage{
+			textMessage(fmt.Sprintf(
+				"Summarize chapter %d of \"%s\" by %s. Cover the main events or arguments, the characters or "+
+					"concepts introduced, and how the chapter fits into the book. The book metadata and the "+
+					"chapter text follow.",
+				chapterIndex, book.Title, strings.Join(book.Authors, ", "))),
+			bookMsg,
+			chapterMsg,
+		},
+	}, nil
+}
+
+func compareBooksPrompt(ctx context.Context, req *mcp.GetPromptRequest, db *calibre.DB) (*mcp.GetPromptResult, error) {
+	firstID, err := promptIntArg(req, "book_id")
+	if err != nil {
+		return nil, err
+	}
+	secondID, err := promptIntArg(req, "other_book_id")
+	if err != nil {
+		return nil, err
+	}
+
+	firstMsg, first, err := bookMessage(ctx, db, firstID)
+	if err != nil {
+		return nil, err
+	}
+	secondMsg, second, err := bookMessage(ctx, db, secondID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcp.GetPromptResult{
+		Description: fmt.Sprintf("Compare %s and %s", first.Title, second.Title),
+		Messages: []*mcp.PromptMessage{
+			textMessage(fmt.Sprintf(
+				"Compare \"%s\" and \"%s\". Discuss their authors, subjects, genres, style and reception, and "+
+					"say which readers would prefer each one. Use the book metadata below, and the chapter "+
+					"tools if you need to look at the text.",
+				first.Title, second.Title)),
+			firstMsg,
+			secondMsg,
+		},
+	}, nil
+}
+
+func seriesReadingPlanPrompt(ctx context.Context, req *mcp.GetPromptRequest, db *calibre.DB) (*mcp.GetPromptResult, error) {
+	series := strings.TrimSpace(req.Params.Arguments["series"])
+	if series == "" {
+		return nil, fmt.Errorf("missing required argument %q", "series")
+	}
+
+	books, err := calibre.GetSeriesBooks(ctx, db, series)
+	if err != nil {
+		return nil, fmt.Errorf("series %q: %w", series, err)
+	}
+	data, err := json.Marshal(books)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcp.GetPromptResult{
+		Description: fmt.Sprintf("Build a reading plan for the %s series", series),
+		Messages: []*mcp.PromptMessage{
+			textMessage(fmt.Sprintf(
+				"Build a reading plan for the \"%s\" series from the books I own, listed below in series "+
+					"order. Suggest a reading order, point out any missing volumes, and estimate the time "+
+					"each book needs from its size.",
+				series)),
+			textMessage(string(data)),
+		},
+	}, nil
+}
+
+func similarBooksPrompt(ctx context.Context, req *mcp.GetPromptRequest, db *calibre.DB) (*mcp.GetPromptResult, error) {
+	bookID, err := promptIntArg(req, "book_id")
+	if err != nil {
+		return nil, err
+	}
+
+	bookMsg, book, err := bookMessage(ctx, db, bookID)
+	if err != nil {
+		return nil, err
+	}
+	similar, err := calibre.FindSimilarBooks(ctx, db, bookID, similarBooksLimit)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(similar)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcp.GetPromptResult{
+		Description: fmt.Sprintf("Find books similar to %s", book.Title),
+		Messages: []*mcp.PromptMessage{
+			textMessage(fmt.Sprintf(
+				"Find books in my library similar to \"%s\". Below are its metadata and candidates that share "+
+					"authors, series or tags with it, scored by how much they share. Rank the best matches, "+
+					"explain each choice, and use search_books to look for other candidates if needed.",
+				book.Title)),
+			bookMsg,
+			textMessage(string(data)),
+		},
+	}, nil
+}
+
+func explainPassagePrompt(ctx context.Context, req *mcp.GetPromptRequest, db *calibre.DB, libraryPath string) (*mcp.GetPromptResult, error) {
+	bookID, err := promptIntArg(req, "book_id")
+	if err != nil {
+		return nil, err
+	}
+	passage := strings.TrimSpace(req.Params.Arguments["passage"])
+	if passage == "" {
+		return nil, fmt.Errorf("missing required argument %q", "passage")
+	}
+
+	bookMsg, book, err := bookMessage(ctx, db, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Locate the passage when the chapter isn't given
+	chapterIndex := -1
+	if req.Params.Arguments["chapter_index"] != "" {
+		chapterIndex, err = promptIntArg(req, "chapter_index")
+		if err != nil {
+			return nil, err
+		}
+	} else if matches, err := calibre.SearchEPUBContent(db, libraryPath, bookID, passage, 1, 0); err == nil && len(matches) > 0 {
+		chapterIndex = matches[0].ChapterIndex
+	}
+
+	messages := []*mcp.PromptMessage{
+		textMessage(fmt.Sprintf(
+			"Explain this passage from \"%s\" by %s, including its meaning, any references or difficult "+
+				"language, and its role in the surrounding text:\n\n%s",
+			book.Title, strings.Join(book.Authors, ", "), passage)),
+		bookMsg,
+	}
+	if chapterIndex >= 0 {
+		chapterMsg, err := chapterMessage(db, libraryPath, bookID, chapterIndex)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, chapterMsg)
+	}
+
+	return &mcp.GetPromptResult{
+		Description: fmt.Sprintf("Explain a passage of %s", book.Title),
+		Messages:    messages,
+	}, nil
+}
+
+// addPrompts registers the prompts for common library workflows
+func addPrompts(server *mcp.Server, db *calibre.DB, libraryPath string) {
+	server.AddPrompt(&mcp.Prompt{
+		Name:        "summarize_chapter",
+		Title:       "Summarize a chapter",
+		Description: "Summarize a chapter of an EPUB book, with the chapter text embedded",
+		Arguments: []*mcp.PromptArgument{
+			{Name: "book_id", Description: "Book ID", Required: true},
+			{Name: "chapter_index", Description: "Chapter index (starting from 0)", Required: true},
+		},
+	}, func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return summarizeChapterPrompt(ctx, req, db, libraryPath)
+	})
+
+	server.AddPrompt(&mcp.Prompt{
+		Name:        "compare_books",
+		Title:       "Compare two books",
+		Description: "Compare two books of the library, with their metadata embedded",
+		Arguments: []*mcp.PromptArgument{
+			{Name: "book_id", Description: "ID of the first book", Required: true},
+			{Name: "other_book_id", Description: "ID of the second book", Required: true},
+		},
+	}, func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return compareBooksPrompt(ctx, req, db)
+	})
+
+	server.AddPrompt(&mcp.Prompt{
+		Name:        "series_reading_plan",
+		Title:       "Build a reading plan for a series",
+		Description: "Build a reading plan for a series from the books owned in the library",
+		Arguments: []*mcp.PromptArgument{
+			{Name: "series", Description: "Series name", Required: true},
+		},
+	}, func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return seriesReadingPlanPrompt(ctx, req, db)
+	})
+
+	server.AddPrompt(&mcp.Prompt{
+		Name:        "similar_books",
+		Title:       "Find similar books",
+		Description: "Find books in the library similar to a given book",
+		Arguments: []*mcp.PromptArgument{
+			{Name: "book_id", Description: "Book ID", Required: true},
+		},
+	}, func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return similarBooksPrompt(ctx, req, db)
+	})
+
+	server.AddPrompt(&mcp.Prompt{
+		Name:        "explain_passage",
+		Title:       "Explain a passage",
+		Description: "Explain a passage of an EPUB book, with the surrounding chapter embedded",
+		Arguments: []*mcp.PromptArgument{
+			{Name: "book_id", Description: "Book ID", Required: true},
+			{Name: "passage", Description: "Text of the passage", Required: true},
+			{Name: "chapter_index", Description: "Chapter containing the passage, found by searching when omitted"},
+		},
+	}, func(ctx context.Context, req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return explainPassagePrompt(ctx, req, db, libraryPath)
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type currentlyReadingInput struct {
+	Limit int `json:"limit,omitempty"`
+}
+
+type currentlyReadingOutput struct {
+	Books []calibre.ReadingBook `json:"books"`
+}
+
+type resumeReadingInput struct {
+	BookID int `json:"book_id"`
+	Length int `json:"length,omitempty"`
+}
+
+type resumeReadingOutput struct {
+	Resume *calibre.ResumePoint `json:"resume"`
+}
+
+func currentlyReading(ctx context.Context, req *mcp.CallToolRequest, input currentlyReadingInput, db *calibre.DB) (
+	*mcp.CallToolResult,
+	*currentlyReadingOutput,
+	error,
+) {
+	books, err := calibre.GetCurrentlyReading(ctx, db, input.Limit)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, "Books being read, most recent first:")
+	contentLines = append(contentLines, "")
+	if len(books) == 0 {
+		contentLines = append(contentLines, "No books in progress.")
+	}
+	for _, book := range books {
+		contentLines = append(contentLines, fmt.Sprintf("- %s by %s (ID: %d)", book.Title, strings.Join(book.Authors, ", "), book.BookID))
+		contentLines = append(contentLines, fmt.Sprintf("  %s", formatReadingPosition(book.Position)))
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &currentlyReadingOutput{Books: books}, nil
+}
+
+func resumeReading(ctx context.Context, req *mcp.CallToolRequest, input resumeReadingInput, db *calibre.DB, libraryPath string) (
+	*mcp.CallToolResult,
+	*resumeReadingOutput,
+	error,
+) {
+	resume, err := calibre.ResumeReading(ctx, db, libraryPath, input.BookID, input.Length)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("Resuming %s (ID: %d)", resume.Title, resume.BookID))
+	contentLines = append(contentLines, formatReadingPosition(resume.Position))
+	contentLines = append(contentLines, fmt.Sprintf("Chapter %d: %s, offset %d",
+		resume.Location.ChapterIndex, resume.Location.ChapterTitle, resume.Location.Offset))
+	contentLines = append(contentLines, "")
+	contentLines = append(contentLines, resume.Text)
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &resumeReadingOutput{Resume: resume}, nil
+}
+
+// formatReadingPosition describes a reading position, leaving out the
+// placeholder Calibre records as user and device of its desktop viewer
+func formatReadingPosition(p calibre.ReadingPosition) string {
+	text := fmt.Sprintf("%.0f%% of the %s, last read %s", p.Progress*100, p.Format, p.LastRead)
+	var by []string
+	for _, name := range []string{p.User, p.Device} {
+		if name != "" && name != "_" {
+			by = append(by, name)
+		}
+	}
+	if len(by) > 0 {
+		text += fmt.Sprintf(" (%s)", strings.Join(by, ", "))
+	}
+	return text
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/yosida95/uritemplate/v3"
+)
+
+var (
+	bookTemplate    = uritemplate.MustNew("calibre://book/{id}")
+	coverTemplate   = uritemplate.MustNew("calibre://book/{id}/cover")
+	chapterTemplate = uritemplate.MustNew("calibre://book/{id}/chapter/{index}")
+	formatTemplate  = uritemplate.MustNew("calibre://book/{id}/format/{fmt}")
+)
+
+// matchURI extracts the integer variables of a resource URI. It returns false
+// if the URI doesn't match the template or a variable isn't an integer.
+func matchURI(tmpl *uritemplate.Template, uri string, names ...string) ([]int, bool) {
+	values := tmpl.Match(uri)
+	if values == nil {
+		return nil, false
+	}
+	ints := make([]int, len(names))
+	for i, name := range names {
+		n, err := strconv.Atoi(values.Get(name).String())
+		if err != nil {
+			return nil, false
+		}
+		ints[i] = n
+	}
+	return ints, true
+}
+
+func readBookResource(ctx context.Context, req *mcp.ReadResourceRequest, db *calibre.DB) (*mcp.ReadResourceResult, error) {
+	uri := req.Params.URI
+	ids, ok := matchURI(bookTemplate, uri, "id")
+	if !ok {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+
+	book, err := calibre.GetBook(ctx, db, ids[0])
+	if err != nil {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+
+	data, err := json.Marshal(book)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcp.ReadResourceResult{
+		Contents: []*mcp.ResourceContents{
+			{URI: uri, MIMEType: "application/json", Text: string(data)},
+		},
+	}, nil
+}
+
+func readCoverResource(ctx context.Context, req *mcp.ReadResourceRequest, db *calibre.DB, libraryPath string) (*mcp.ReadResourceResult, error) {
+	uri := req.Params.URI
+	ids, ok := matchURI(coverTemplate, uri, "id")
+	if !ok {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+
+	cover, err := calibre.GetBookCover(db, libraryPath, ids[0], 0)
+	if err != nil {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+
+	return &mcp.ReadResourceResult{
+		Contents: []*mcp.ResourceContents{
+			{URI: uri, MIMEType: cover.MIMEType, Blob: cover.Data},
+		},
+	}, nil
+}
+
+func readChapterResource(ctx context.Context, req *mcp.ReadResourceRequest, db *calibre.DB, libraryPath string) (*mcp.ReadResourceResult, error) {
+	uri := req.Params.URI
+	ids, ok := matchURI(chapterTemplate, uri, "id", "index")
+	if !ok {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+
+	content, err := calibre.GetEPUBChapterContent(db, libraryPath, ids[0], ids[1])
+	if err != nil {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+
+	return &mcp.ReadResourceResult{
+		Contents: []*mcp.ResourceContents{
+			{URI: uri, MIMEType: "text/plain", Text: content},
+		},
+	}, nil
+}
+
+func readFormatResource(ctx context.Context, req *mcp.ReadResourceRequest, db *calibre.DB, libraryPath string) (*mcp.ReadResourceResult, error) {
+	uri := req.Params.URI
+	ids, ok := matchURI(formatTemplate, uri, "id")
+	if !ok {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+	format := formatTemplate.Match(uri).Get("fmt").String()
+
+	data, err := calibre.ReadBookFormat(db, libraryPath, ids[0], format)
+	if err != nil {
+		return nil, mcp.ResourceNotFoundError(uri)
+	}
+
+	return &mcp.ReadResourceResult{
+		Contents: []*mcp.ResourceContents{
+			{URI: uri, MIMEType: calibre.FormatMediaType(format), Blob: data},
+		},
+	}, nil
+}
+
+// addResourceTemplates registers the resource templates for books, covers,
+// chapters and book files
+func addResourceTemplates(server *mcp.Server, db *calibre.DB, libraryPath string) {
+	addBookTemplate(server, db)
+
+	server.AddResourceTemplate(&mcp.ResourceTemplate{
+		Name:        "book_cover",
+		Title:       "Book cover",
+		Description: "Cover image of a book from the Calibre library",
+		URITemplate: coverTemplate.Raw(),
+	}, func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+		return readCoverResource(ctx, req, db, libraryPath)
+	})
+
+	server.AddResourceTemplate(&mcp.ResourceTemplate{
+		Name:        "book_chapter",
+		Title:       "EPUB chapter",
+		Description: "Text content of a chapter of an EPUB book from the Calibre library, starting from index 0",
+		MIMEType:    "text/plain",
+		URITemplate: chapterTemplate.Raw(),
+	}, func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+		return readChapterResource(ctx, req, db, libraryPath)
+	})
+
+	server.AddResourceTemplate(&mcp.ResourceTemplate{
+		Name:        "book_format",
+		Title:       "Book file",
+		Description: "Raw file of a book from the Calibre library in the given format, such as EPUB or PDF",
+		URITemplate: formatTemplate.Raw(),
+	}, func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+		return readFormatResource(ctx, req, db, libraryPath)
+	})
+}
+
+// addBookTemplate registers the resource template of book metadata. Adding it
+// again replaces it and sends resources/list_changed to every client.
+func addBookTemplate(server *mcp.Server, db *calibre.DB) {
+	server.AddResourceTemplate(&mcp.ResourceTemplate{
+		Name:        "book",
+		Title:       "Book metadata",
+		Description: "Detailed metadata of a book from the Calibre library",
+		MIMEType:    "application/json",
+		URITemplate: bookTemplate.Raw(),
+	}, func(ctx context.Context, req *mcp.ReadResourceRequest) (*mcp.ReadResourceResult, error) {
+		return readBookResource(ctx, req, db)
+	})
+}
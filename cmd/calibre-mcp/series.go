@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type getSeriesInput struct {
+	Series string `json:"series"`
+}
+
+type getSeriesOutput struct {
+	Series *calibre.Series `json:"series"`
+}
+
+func formatIndices(indices []float64) string {
+	values := make([]string, len(indices))
+	for i, index := range indices {
+		values[i] = fmt.Sprintf("#%g", index)
+	}
+	return strings.Join(values, ", ")
+}
+
+func getSeries(ctx context.Context, req *mcp.CallToolRequest, input getSeriesInput, db *calibre.DB, readColumn string) (
+	*mcp.CallToolResult,
+	*getSeriesOutput,
+	error,
+) {
+	series, err := calibre.GetSeries(ctx, db, input.Series, readColumn)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	contentLines = append(contentLines, fmt.Sprintf("# %s", series.Name))
+	contentLines = append(contentLines, "")
+	for _, volume := range series.Volumes {
+		line := fmt.Sprintf("#%g %s by %s (ID: %d)", volume.SeriesIndex, volume.Title, strings.Join(volume.Authors, ", "), volume.ID)
+		if volume.Read != nil {
+			if *volume.Read {
+				line += " - read"
+			} else {
+				line += " - unread"
+			}
+		}
+		contentLines = append(contentLines, line)
+	}
+	contentLines = append(contentLines, "")
+	if len(series.Gaps) > 0 {
+		missing := formatIndices(series.Gaps)
+		if series.GapsOmitted > 0 {
+			missing += fmt.Sprintf(" and %d more", series.GapsOmitted)
+		}
+		contentLines = append(contentLines, fmt.Sprintf("**Missing:** %s", missing))
+	}
+	if len(series.DuplicateIndices) > 0 {
+		contentLines = append(contentLines, fmt.Sprintf("**Duplicate indices:** %s", formatIndices(series.DuplicateIndices)))
+	}
+	if series.ReadColumn == "" {
+		contentLines = append(contentLines, "**Read state:** unknown, no read column found")
+	}
+	if series.Next != nil {
+		contentLines = append(contentLines, fmt.Sprintf("**Next to read:** #%g %s (ID: %d)", series.Next.SeriesIndex, series.Next.Title, series.Next.ID))
+	} else {
+		contentLines = append(contentLines, "**Next to read:** none, the owned volumes are all read")
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &getSeriesOutput{Series: series}, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type libraryStatsInput struct {
+	Query string `json:"query,omitempty"`
+}
+
+type libraryStatsOutput struct {
+	Stats *calibre.LibraryStats `json:"stats"`
+}
+
+func formatCounts(counts []calibre.StatsCount) string {
+	values := make([]string, len(counts))
+	for i, c := range counts {
+		values[i] = fmt.Sprintf("%s (%d)", c.Name, c.Count)
+	}
+	return strings.Join(values, ", ")
+}
+
+func libraryStats(ctx context.Context, req *mcp.CallToolRequest, input libraryStatsInput, db *calibre.DB) (
+	*mcp.CallToolResult,
+	*libraryStatsOutput,
+	error,
+) {
+	stats, err := calibre.GetLibraryStats(ctx, db, input.Query)
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	// Format the display text
+	var contentLines []string
+	if input.Query != "" {
+		contentLines = append(contentLines, fmt.Sprintf("# Library statistics for '%s'", input.Query))
+	} else {
+		contentLines = append(contentLines, "# Library statistics")
+	}
+	contentLines = append(contentLines, "")
+	contentLines = append(contentLines, fmt.Sprintf("**Books:** %d (%d added this year)", stats.Books, stats.AddedThisYear))
+	contentLines = append(contentLines, fmt.Sprintf("**Authors:** %d", stats.Authors))
+	contentLines = append(contentLines, fmt.Sprintf("**Series:** %d", stats.Series))
+	contentLines = append(contentLines, fmt.Sprintf("**Tags:** %d", stats.Tags))
+	contentLines = append(contentLines, fmt.Sprintf("**Files:** %d, %d bytes", stats.Files, stats.TotalSize))
+	contentLines = append(contentLines, "")
+	contentLines = append(contentLines, fmt.Sprintf("**Languages:** %s", formatCounts(stats.ByLanguage)))
+	contentLines = append(contentLines, fmt.Sprintf("**Formats:** %s", formatCounts(stats.ByFormat)))
+	contentLines = append(contentLines, fmt.Sprintf("**Top tags:** %s", formatCounts(stats.TopTags)))
+	contentLines = append(contentLines, fmt.Sprintf("**Top authors:** %s", formatCounts(stats.TopAuthors)))
+	contentLines = append(contentLines, fmt.Sprintf("**Publication decades:** %s", formatCounts(stats.ByDecade)))
+	contentLines = append(contentLines, fmt.Sprintf("**Added by month:** %s", formatCounts(stats.ByAddedMonth)))
+	contentLines = append(contentLines, fmt.Sprintf("**Ratings (stars):** %s", formatCounts(stats.RatingHistogram)))
+
+	if len(stats.AverageRatingByTag) > 0 {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, "**Average rating by tag:**")
+		for _, r := range stats.AverageRatingByTag {
+			contentLines = append(contentLines, fmt.Sprintf("- %s: %g stars (%d rated books)", r.Tag, r.AverageRating, r.RatedBooks))
+		}
+	}
+	if len(stats.StorageByFormat) > 0 {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, "**Storage by format:**")
+		for _, s := range stats.StorageByFormat {
+			contentLines = append(contentLines, fmt.Sprintf("- %s: %d files, %d bytes", s.Format, s.Files, s.Size))
+		}
+	}
+	if len(stats.LargestBooks) > 0 {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, "**Largest books:**")
+		for _, b := range stats.LargestBooks {
+			contentLines = append(contentLines, fmt.Sprintf("- %s (ID: %d): %d bytes", b.Title, b.ID, b.Size))
+		}
+	}
+	if len(stats.BooksWithoutFormats) > 0 {
+		contentLines = append(contentLines, "")
+		contentLines = append(contentLines, "**Books without formats:**")
+		for _, b := range stats.BooksWithoutFormats {
+			contentLines = append(contentLines, fmt.Sprintf("- %s (ID: %d)", b.Title, b.ID))
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: strings.Join(contentLines, "\n")},
+		},
+	}, &libraryStatsOutput{Stats: stats}, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// summaryMaxTokens bounds the length of each sampled summary
+const summaryMaxTokens = 1024
+
+type summarizeChapterInput struct {
+	BookID       int `json:"book_id"`
+	ChapterIndex int `json:"chapter_index"`
+}
+
+type summarizeBookInput struct {
+	BookID int `json:"book_id"`
+}
+
+type summarizeOutput struct {
+	Summary *calibre.Summary `json:"summary"`
+}
+
+// sessionSampler returns a sample function that asks the client's language
+// model through MCP sampling
+func sessionSampler(session *mcp.ServerSession) (calibre.SampleFunc, error) {
+	if params := session.InitializeParams(); params == nil || params.Capabilities == nil || params.Capabilities.Sampling == nil {
+		return nil, errors.New("the client does not support sampling, which is required to summarize")
+	}
+	return func(ctx context.Context, prompt string) (string, error) {
+		res, err := session.CreateMessage(ctx, &mcp.CreateMessageParams{
+			Messages: []*mcp.SamplingMessage{
+				{Role: "user", Content: &mcp.TextContent{Text: prompt}},
+			},
+			SystemPrompt: "You summarize books accurately and concisely. Reply with the summary only.",
+			MaxTokens:    summaryMaxTokens,
+		})
+		if err != nil {
+			return "", err
+		}
+		text, ok := res.Content.(*mcp.TextContent)
+		if !ok {
+			return "", errors.New("the client returned a non-text summary")
+		}
+		return text.Text, nil
+	}, nil
+}
+
+func summarizeChapter(ctx context.Context, req *mcp.CallToolRequest, input summarizeChapterInput, db *calibre.DB, libraryPath string, cache *calibre.SummaryCache) (
+	*mcp.CallToolResult,
+	*summarizeOutput,
+	error,
+) {
+	summary, err := func() (*calibre.Summary, error) {
+		sample, err := sessionSampler(req.Session)
+		if err != nil {
+			return nil, err
+		}
+		return calibre.NewSummarizer(db, libraryPath, cache, sample).SummarizeChapter(ctx, input.BookID, input.ChapterIndex)
+	}()
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: fmt.Sprintf("Summary of chapter %d of book ID %d:\n\n%s", input.ChapterIndex, input.BookID, summary.Summary)},
+		},
+	}, &summarizeOutput{Summary: summary}, nil
+}
+
+func summarizeBook(ctx context.Context, req *mcp.CallToolRequest, input summarizeBookInput, db *calibre.DB, libraryPath string, cache *calibre.SummaryCache) (
+	*mcp.CallToolResult,
+	*summarizeOutput,
+	error,
+) {
+	summary, err := func() (*calibre.Summary, error) {
+		sample, err := sessionSampler(req.Session)
+		if err != nil {
+			return nil, err
+		}
+		return calibre.NewSummarizer(db, libraryPath, cache, sample).SummarizeBook(ctx, input.BookID)
+	}()
+	if err != nil {
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				&mcp.TextContent{Text: err.Error()},
+			},
+			IsError: true,
+		}, nil, nil
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: fmt.Sprintf("Summary of book ID %d:\n\n%s", input.BookID, summary.Summary)},
+		},
+	}, &summarizeOutput{Summary: summary}, nil
+}
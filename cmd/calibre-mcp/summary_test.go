@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// connectSession connects a client with the given options to a server, a new
+// one when server is nil, and returns the server's side of the session
+func connectSession(t *testing.T, server *mcp.Server, opts *mcp.ClientOptions) *mcp.ServerSession {
+	t.Helper()
+	ctx := context.Background()
+	if server == nil {
+		server = mcp.NewServer(&mcp.Implementation{Name: "calibre-mcp", Version: "test"}, nil)
+	}
+	client := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "test"}, opts)
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+
+	serverSession, err := server.Connect(ctx, serverTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { serverSession.Close() })
+	clientSession, err := client.Connect(ctx, clientTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { clientSession.Close() })
+	return serverSession
+}
+
+func TestSessionSampler(t *testing.T) {
+	var got *mcp.CreateMessageParams
+	session := connectSession(t, nil, &mcp.ClientOptions{
+		CreateMessageHandler: func(ctx context.Context, req *mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+			got = req.Params
+			return &mcp.CreateMessageResult{
+				Role:    "assistant",
+				Model:   "fake",
+				Content: &mcp.TextContent{Text: "A summary."},
+			}, nil
+		},
+	})
+
+	sample, err := sessionSampler(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := sample(context.Background(), "Summarize this.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != "A summary." {
+		t.Errorf("summary = %q, want the text of the client's reply", summary)
+	}
+
+	if got == nil || len(got.Messages) != 1 {
+		t.Fatalf("sampling request = %+v, want one message", got)
+	}
+	if text, ok := got.Messages[0].Content.(*mcp.TextContent); !ok || text.Text != "Summarize this." || got.Messages[0].Role != "user" {
+		t.Errorf("message = %+v, want the prompt from the user", got.Messages[0])
+	}
+	if got.MaxTokens != summaryMaxTokens || got.SystemPrompt == "" {
+		t.Errorf("request has max tokens %d and system prompt %q", got.MaxTokens, got.SystemPrompt)
+	}
+}
+
+func TestSessionSamplerNonText(t *testing.T) {
+	session := connectSession(t, nil, &mcp.ClientOptions{
+		CreateMessageHandler: func(ctx context.Context, req *mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+			return &mcp.CreateMessageResult{
+				Role:    "assistant",
+				Model:   "fake",
+				Content: &mcp.ImageContent{Data: []byte{0}, MIMEType: "image/png"},
+			}, nil
+		},
+	})
+
+	sample, err := sessionSampler(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sample(context.Background(), "Summarize this."); err == nil {
+		t.Error("sampling returned an image without an error")
+	}
+}
+
+func TestSessionSamplerWithoutSampling(t *testing.T) {
+	session := connectSession(t, nil, nil)
+	if _, err := sessionSampler(session); err == nil {
+		t.Error("sessionSampler succeeded for a client without sampling")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// subscriptions tracks the resource URIs that clients subscribed to, so that
+// a change to a book can be reported for its chapters and files as well
+type subscriptions struct {
+	mu   sync.Mutex
+	uris map[string]int
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{uris: make(map[string]int)}
+}
+
+func (s *subscriptions) subscribe(ctx context.Context, req *mcp.SubscribeRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uris[req.Params.URI]++
+	return nil
+}
+
+func (s *subscriptions) unsubscribe(ctx context.Context, req *mcp.UnsubscribeRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.uris[req.Params.URI] <= 1 {
+		delete(s.uris, req.Params.URI)
+	} else {
+		s.uris[req.Params.URI]--
+	}
+	return nil
+}
+
+// forBook returns the subscribed URIs that belong to a book
+func (s *subscriptions) forBook(bookID int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uri := bookURI(bookID)
+	var uris []string
+	for subscribed := range s.uris {
+		if subscribed == uri || strings.HasPrefix(subscribed, uri+"/") {
+			uris = append(uris, subscribed)
+		}
+	}
+	return uris
+}
+
+// bookURI returns the resource URI of a book
+func bookURI(bookID int) string {
+	return fmt.Sprintf("calibre://book/%d", bookID)
+}
+
+// notifyResourceListChanged sends resources/list_changed to every client, so
+// that they list the books again. The SDK has no call to send it and sends it
+// whenever a resource template is added, so the book template, through which
+// books are served, is added again in place of itself.
+func notifyResourceListChanged(server *mcp.Server, db *calibre.DB) {
+	addBookTemplate(server, db)
+}
+
+// handleLibraryChange drops stale caches and notifies the clients: every
+// client when books were added or removed, and the clients subscribed to the
+// affected books
+func handleLibraryChange(ctx context.Context, server *mcp.Server, db *calibre.DB, subs *subscriptions, change *calibre.LibraryChange) {
+	calibre.ClearCaches()
+	clearBooksSearchCache()
+
+	if len(change.Added) > 0 || len(change.Removed) > 0 {
+		notifyResourceListChanged(server, db)
+	}
+
+	for _, ids := range [][]int{change.Added, change.Changed, change.Removed} {
+		for _, id := range ids {
+			for _, uri := range subs.forBook(id) {
+				server.ResourceUpdated(ctx, &mcp.ResourceUpdatedNotificationParams{URI: uri})
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benoute/calibre-mcp/pkg/calibre"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestHandleLibraryChangeListChanged(t *testing.T) {
+	server := mcp.NewServer(&mcp.Implementation{Name: "calibre-mcp", Version: "test"}, nil)
+	addBookTemplate(server, nil)
+	changed := make(chan struct{}, 1)
+	connectSession(t, server, &mcp.ClientOptions{
+		ResourceListChangedHandler: func(ctx context.Context, req *mcp.ResourceListChangedRequest) {
+			changed <- struct{}{}
+		},
+	})
+
+	handleLibraryChange(context.Background(), server, nil, newSubscriptions(), &calibre.LibraryChange{Added: []int{7}})
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no resources/list_changed after a book was added")
+	}
+}
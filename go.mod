@@ -3,13 +3,14 @@ module github.com/benoute/calibre-mcp
 go 1.25.4
 
 require (
+	github.com/google/jsonschema-go v0.3.0
+	github.com/gosimple/unidecode v1.0.1
 	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/modelcontextprotocol/go-sdk v1.1.0
 	github.com/rs/cors v1.11.1
+	github.com/yosida95/uritemplate/v3 v3.0.2
+	golang.org/x/image v0.25.0
+	golang.org/x/text v0.23.0
 )
 
-require (
-	github.com/google/jsonschema-go v0.3.0 // indirect
-	github.com/yosida95/uritemplate/v3 v3.0.2 // indirect
-	golang.org/x/oauth2 v0.30.0 // indirect
-)
+require golang.org/x/oauth2 v0.30.0 // indirect
@@ -0,0 +1,500 @@
+package calibre
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gosimple/unidecode"
+	"golang.org/x/text/unicode/norm"
+)
+
+// errDuplicate stops adding a book found in the library by the check made
+// within the transaction
+var errDuplicate = errors.New("the library has the book already")
+
+// pathLimit is the length Calibre gives to the names of book folders and
+// files, outside of Windows
+const pathLimit = 100
+
+// languageCodes maps the two-letter language codes found in EPUBs to the
+// ISO 639-3 codes Calibre stores
+var languageCodes = map[string]string{
+	"ar": "ara", "ca": "cat", "cs": "ces", "da": "dan", "de": "deu", "el": "ell", "en": "eng", "es": "spa",
+	"fi": "fin", "fr": "fra", "he": "heb", "hi": "hin", "hu": "hun", "it": "ita", "ja": "jpn", "ko": "kor",
+	"nl": "nld", "no": "nor", "pl": "pol", "pt": "por", "ro": "ron", "ru": "rus", "sv": "swe", "tr": "tur",
+	"uk": "ukr", "zh": "zho",
+}
+
+// FileMetadata is the metadata of a book file about to be added. For EPUBs it
+// is read from the OPF, and for other formats guessed from the file name.
+type FileMetadata struct {
+	Format      string            `json:"format"`
+	Title       string            `json:"title"`
+	Authors     []string          `json:"authors"`
+	Languages   []string          `json:"languages"`
+	Identifiers map[string]string `json:"identifiers"`
+	Description string            `json:"description,omitempty"`
+	HasCover    bool              `json:"has_cover"`
+
+	cover []byte
+}
+
+// AddOptions tune how a book is added
+type AddOptions struct {
+	Title          string   // title to use instead of the one read from the file
+	Authors        []string // authors to use instead of the ones read from the file
+	AllowDuplicate bool     // add the book even if the library seems to have it already
+	DryRun         bool     // only read the metadata and look for duplicates
+}
+
+// AddResult describes a book added to the library. BookID is zero when the
+// book was not added, because of a dry run or duplicates. Warning tells why a
+// book that was added has no change in the journal.
+type AddResult struct {
+	BookID     int             `json:"book_id,omitempty"`
+	ChangeID   int             `json:"change_id,omitempty"`
+	Path       string          `json:"path,omitempty"`
+	Metadata   *FileMetadata   `json:"metadata"`
+	Duplicates []BookCandidate `json:"duplicates"`
+	Warning    string          `json:"warning,omitempty"`
+}
+
+// ReadFileMetadata reads the metadata of a book file
+func ReadFileMetadata(filePath string) (*FileMetadata, error) {
+	name := filepath.Base(filePath)
+	ext := filepath.Ext(name)
+	if ext == "" || ext == name {
+		return nil, fmt.Errorf("%s has no extension to tell its format", name)
+	}
+	meta := &FileMetadata{
+		Format:      strings.ToUpper(ext[1:]),
+		Languages:   []string{},
+		Identifiers: make(map[string]string),
+	}
+
+	if meta.Format == "EPUB" {
+		if err := meta.readEPUB(filePath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fall back to a "Title - Author" file name, as Calibre does
+	if meta.Title == "" || len(meta.Authors) == 0 {
+		title, author, ok := strings.Cut(strings.TrimSuffix(name, ext), " - ")
+		if meta.Title == "" {
+			meta.Title = strings.TrimSpace(title)
+		}
+		if len(meta.Authors) == 0 && ok && strings.TrimSpace(author) != "" {
+			meta.Authors = []string{strings.TrimSpace(author)}
+		}
+	}
+	if len(meta.Authors) == 0 {
+		meta.Authors = []string{"Unknown"}
+	}
+	return meta, nil
+}
+
+// readEPUB reads the title, authors, languages, identifiers, description and
+// cover declared in the OPF of an EPUB
+func (meta *FileMetadata) readEPUB(filePath string) error {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	defer r.Close()
+
+	pkg, opfPath, err := readPackage(r)
+	if err != nil {
+		return err
+	}
+	md := pkg.Metadata
+
+	meta.Title = md.title()
+	for _, author := range md.authors() {
+		meta.Authors = append(meta.Authors, author.Name)
+	}
+	for _, code := range md.languages() {
+		if !slices.Contains(meta.Languages, code) {
+			meta.Languages = append(meta.Languages, code)
+		}
+	}
+	meta.Identifiers = md.identifiers()
+	meta.Description = strings.TrimSpace(md.Description)
+
+	if item, ok := pkg.coverItem(); ok {
+		f, err := r.Open(resolveHref(opfPath, item.Href))
+		if err == nil {
+			meta.cover, err = io.ReadAll(f)
+			f.Close()
+		}
+		if err == nil {
+			meta.cover, err = toJPEG(meta.cover)
+		}
+		meta.HasCover = err == nil
+	}
+	return nil
+}
+
+// languageCode returns the ISO 639-3 code Calibre stores for a language tag
+// such as en-US, or an empty string if it is unknown
+func languageCode(tag string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	if code, ok := languageCodes[lang]; ok {
+		return code
+	}
+	if len(lang) == 3 {
+		return lang
+	}
+	return ""
+}
+
+// calibreIdentifier returns the type and value Calibre stores for an OPF
+// identifier, such as isbn and 9780441013593 for urn:isbn:9780441013593. The
+// UUID and ID of Calibre books are skipped, as the new book gets its own.
+func (id Identifier) calibreIdentifier() (string, string, bool) {
+	value := strings.TrimSpace(id.Value)
+	scheme := strings.ToLower(strings.TrimSpace(id.Scheme))
+	if rest, ok := strings.CutPrefix(strings.ToLower(value), "urn:"); ok {
+		scheme, _, _ = strings.Cut(rest, ":")
+		value = value[len("urn:")+len(scheme)+1:]
+	} else if typ, val, ok := strings.Cut(value, ":"); ok && scheme == "" && !strings.Contains(typ, "/") {
+		scheme, value = strings.ToLower(typ), val
+	}
+	value = strings.TrimSpace(value)
+
+	switch {
+	case value == "" || scheme == "":
+		return "", "", false
+	case scheme == "uuid" || scheme == "calibre":
+		return "", "", false
+	case strings.HasPrefix(scheme, "isbn"):
+		if normalizeISBN(value) == "" {
+			return "", "", false
+		}
+		return "isbn", strings.ReplaceAll(strings.ReplaceAll(value, "-", ""), " ", ""), true
+	}
+	return scheme, value, true
+}
+
+// AddBook adds a book file to the library, the way Calibre does: it creates
+// the books and data rows, with the metadata read from the file, and copies
+// the file to an Author/Title (id) folder along with the cover. When the
+// library seems to have the book already, with the same title and authors or
+// a shared identifier, the book is only added with opts.AllowDuplicate.
+func AddBook(ctx context.Context, db *DB, libraryPath string, filePath string, opts AddOptions) (*AddResult, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a file", filePath)
+	}
+
+	meta, err := ReadFileMetadata(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(opts.Title) != "" {
+		meta.Title = strings.TrimSpace(opts.Title)
+	}
+	var authors []string
+	for _, author := range opts.Authors {
+		if author = strings.TrimSpace(author); author != "" {
+			authors = append(authors, author)
+		}
+	}
+	if len(authors) > 0 {
+		meta.Authors = authors
+	}
+	if meta.Title == "" {
+		meta.Title = "Unknown"
+	}
+
+	result := &AddResult{Metadata: meta}
+	result.Duplicates, err = findExistingBooks(ctx, db, meta)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun || (len(result.Duplicates) > 0 && !opts.AllowDuplicate) {
+		return result, nil
+	}
+
+	var folder string
+	set, err := runEdit(ctx, db, editOptions{commit: true}, func(e *editor, set *ChangeSet) error {
+		// Check again now that the transaction holds the write lock, so that
+		// a book added meanwhile is found and another add waits for this one
+		if !opts.AllowDuplicate {
+			duplicates, err := findExistingBooks(ctx, db, meta)
+			if err != nil {
+				return err
+			}
+			if len(duplicates) > 0 {
+				result.Duplicates = duplicates
+				return errDuplicate
+			}
+		}
+
+		id, err := e.insertBook(ctx, meta)
+		if err != nil {
+			return err
+		}
+		result.BookID = id
+		result.Path = bookFolderName(id, meta.Title, meta.Authors[0])
+
+		// Copy the file in, which is undone below if the transaction fails
+		folder = filepath.Join(libraryPath, filepath.FromSlash(result.Path))
+		fileName := bookFileName(meta.Title, meta.Authors[0])
+		if err := e.storeBookFiles(ctx, id, folder, filePath, fileName, meta); err != nil {
+			return err
+		}
+		if _, err := e.tx.ExecContext(ctx, "UPDATE books SET path = ? WHERE id = ?", result.Path, id); err != nil {
+			return err
+		}
+
+		change := BookChange{BookID: id, Title: meta.Title, Changes: []FieldChange{}}
+		fields := []string{FieldTitle, FieldAuthors, FieldComments}
+		for _, typ := range sortedMapKeys(meta.Identifiers) {
+			fields = append(fields, identifierFieldPrefix+typ)
+		}
+		for _, field := range fields {
+			value, err := e.get(ctx, id, field)
+			if err != nil {
+				return err
+			}
+			if value != "" {
+				change.Changes = append(change.Changes, FieldChange{Field: field, Old: nil, New: value})
+			}
+		}
+		set.Added = []int{id}
+		set.Changes = append(set.Changes, change)
+		return e.touch(ctx, id)
+	})
+	if errors.Is(err, errDuplicate) {
+		return result, nil
+	}
+	// The book is in the library once committed, even if not journaled, so
+	// its files are only removed when the transaction failed
+	var journalErr *JournalError
+	if errors.As(err, &journalErr) {
+		result.Warning = err.Error()
+	} else if err != nil {
+		if folder != "" {
+			removeBookFolder(folder)
+		}
+		return nil, err
+	}
+	result.ChangeID = set.ID
+	return result, nil
+}
+
+// findExistingBooks returns the books of the library with the same title and
+// authors as the file, compared as find_duplicates does, or one of its
+// identifiers
+func findExistingBooks(ctx context.Context, db *DB, meta *FileMetadata) ([]BookCandidate, error) {
+	ids := make(map[int]bool)
+
+	authorKey := func(authors []string) string {
+		keys := make([]string, len(authors))
+		for i, author := range authors {
+			keys[i] = normalizeTitle(author)
+		}
+		sort.Strings(keys)
+		return strings.Join(keys, "&")
+	}
+	titles, err := ListBookTitles(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	title, authors := normalizeTitle(meta.Title), authorKey(meta.Authors)
+	for id, t := range titles {
+		if normalizeTitle(t) != title {
+			continue
+		}
+		bookAuthors, err := getAuthorsForBook(db, id)
+		if err != nil {
+			return nil, err
+		}
+		if authorKey(bookAuthors) == authors {
+			ids[id] = true
+		}
+	}
+
+	for typ, val := range meta.Identifiers {
+		found, err := FindBooksByIdentifier(ctx, db, typ+":"+val)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range found {
+			ids[id] = true
+		}
+	}
+
+	sorted := make([]int, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Ints(sorted)
+	candidates := []BookCandidate{}
+	for _, id := range sorted {
+		c, err := getBookCandidate(ctx, db, id)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, *c)
+	}
+	return candidates, nil
+}
+
+// insertBook creates the books row of a new book and links its metadata. The
+// triggers of Calibre's schema give it its sort key and UUID too.
+func (e *editor) insertBook(ctx context.Context, meta *FileMetadata) (int, error) {
+	now := calibreNow()
+	res, err := e.tx.ExecContext(ctx, `
+		INSERT INTO books (title, sort, author_sort, timestamp, pubdate, series_index, uuid, has_cover, last_modified, path)
+		VALUES (?, ?, '', ?, ?, 1.0, ?, ?, ?, '')
+	`, meta.Title, titleSort(meta.Title), now, undefinedDate, newUUID(), meta.HasCover, now)
+	if err != nil {
+		return 0, err
+	}
+	id64, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	id := int(id64)
+
+	if err := e.set(ctx, id, FieldAuthors, meta.Authors); err != nil {
+		return 0, fmt.Errorf("authors: %w", err)
+	}
+	if _, err := e.setLinkedNames(ctx, languageItems, id, meta.Languages); err != nil {
+		return 0, fmt.Errorf("languages: %w", err)
+	}
+	for typ, val := range meta.Identifiers {
+		if err := e.set(ctx, id, identifierFieldPrefix+typ, val); err != nil {
+			return 0, fmt.Errorf("identifier %s: %w", typ, err)
+		}
+	}
+	if err := e.set(ctx, id, FieldComments, meta.Description); err != nil {
+		return 0, fmt.Errorf("comments: %w", err)
+	}
+	return id, nil
+}
+
+// storeBookFiles creates the folder of a new book, copies its file there
+// along with the cover, and records the file in the data table
+func (e *editor) storeBookFiles(ctx context.Context, bookID int, folder string, filePath string, fileName string, meta *FileMetadata) error {
+	if _, err := os.Stat(folder); err == nil {
+		return fmt.Errorf("book folder %s already exists", folder)
+	}
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		return err
+	}
+
+	size, err := copyFile(filePath, filepath.Join(folder, fileName+"."+strings.ToLower(meta.Format)))
+	if err != nil {
+		return err
+	}
+	if meta.HasCover {
+		if err := os.WriteFile(filepath.Join(folder, coverFileName), meta.cover, 0o644); err != nil {
+			return err
+		}
+	}
+
+	_, err = e.tx.ExecContext(ctx, `
+		INSERT INTO data (book, format, uncompressed_size, name)
+		VALUES (?, ?, ?, ?)
+	`, bookID, meta.Format, size, fileName)
+	return err
+}
+
+func copyFile(src string, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return 0, err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return 0, err
+	}
+	return size, out.Close()
+}
+
+// removeBookFolder removes the folder of a book that could not be added, and
+// its author folder if that leaves it empty
+func removeBookFolder(folder string) {
+	os.RemoveAll(folder)
+	os.Remove(filepath.Dir(folder))
+}
+
+// bookFolderName returns the Author/Title (id) folder Calibre gives a book,
+// relative to the library
+func bookFolderName(bookID int, title string, author string) string {
+	suffix := fmt.Sprintf(" (%d)", bookID)
+	limit := pathLimit - len(suffix)/2 - 2
+
+	author = strings.TrimRight(truncate(asciiFilename(author), limit), " .")
+	if author == "" {
+		author = "Unknown"
+	}
+	title = strings.TrimRight(truncate(asciiFilename(strings.TrimLeft(title, " ")), limit), " ")
+	if title == "" {
+		title = "Unknown"
+	}
+	return author + "/" + title + suffix
+}
+
+// bookFileName returns the "Title - Author" name, without extension, that
+// Calibre gives book files
+func bookFileName(title string, author string) string {
+	limit := (pathLimit - 14 - 2) / 2
+	title = strings.TrimRight(truncate(asciiFilename(strings.TrimLeft(title, " ")), limit), " ")
+	if title == "" {
+		title = "Unknown"
+	}
+	name := strings.TrimRight(title+" - "+truncate(asciiFilename(author), limit), ".")
+	if name == "" {
+		name = "Unknown"
+	}
+	return name
+}
+
+// asciiFilename transliterates a name to ASCII, as Calibre does with its
+// Unidecode tables, so that accents are dropped and other scripts are
+// romanized, and replaces the characters that are not allowed in file names
+func asciiFilename(s string) string {
+	var b strings.Builder
+	for _, r := range unidecode.Unidecode(norm.NFC.String(s)) {
+		switch {
+		case r < 32 || r > 126 || strings.ContainsRune(`\/:*?"<>|`, r):
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
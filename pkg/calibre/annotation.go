@@ -0,0 +1,212 @@
+package calibre
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Annotation is a highlight or bookmark made in Calibre's viewer. Highlights
+// have the highlighted text, notes and color, and bookmarks a title. Location
+// is set when the CFI could be resolved in the EPUB of the book, with the
+// surrounding text as context.
+type Annotation struct {
+	ID              int       `json:"id"`
+	BookID          int       `json:"book_id"`
+	BookTitle       string    `json:"book_title"`
+	Format          string    `json:"format"`
+	User            string    `json:"user"`
+	Type            string    `json:"type"`
+	Title           string    `json:"title,omitempty"`
+	HighlightedText string    `json:"highlighted_text,omitempty"`
+	Notes           string    `json:"notes,omitempty"`
+	Color           string    `json:"color,omitempty"`
+	CFI             string    `json:"cfi"`
+	EndCFI          string    `json:"end_cfi,omitempty"`
+	Timestamp       string    `json:"timestamp"`
+	Location        *Location `json:"location,omitempty"`
+
+	spine int
+}
+
+// AnnotationSearchResult is a page of the annotations matching a search
+type AnnotationSearchResult struct {
+	Annotations []Annotation `json:"annotations"`
+	TotalNum    int          `json:"total_num"`
+}
+
+// annotationData is the JSON Calibre's viewer keeps in annot_data
+type annotationData struct {
+	Type            string `json:"type"`
+	Removed         bool   `json:"removed"`
+	Title           string `json:"title"`
+	Pos             string `json:"pos"`
+	StartCFI        string `json:"start_cfi"`
+	EndCFI          string `json:"end_cfi"`
+	HighlightedText string `json:"highlighted_text"`
+	Notes           string `json:"notes"`
+	SpineIndex      *int   `json:"spine_index"`
+	Timestamp       string `json:"timestamp"`
+	Style           struct {
+		Which           string `json:"which"`
+		BackgroundColor string `json:"background-color"`
+	} `json:"style"`
+}
+
+// GetBookAnnotations returns the highlights and bookmarks of a book in
+// reading order, leaving out the ones removed in the viewer
+func GetBookAnnotations(ctx context.Context, db *DB, libraryPath string, bookID int) ([]Annotation, error) {
+	annotations, err := readAnnotations(ctx, db, "a.book = ?", bookID)
+	if err != nil {
+		return nil, err
+	}
+	locateAnnotations(db, libraryPath, annotations)
+
+	// Annotations that could not be located come last
+	sort.SliceStable(annotations, func(i, j int) bool {
+		a, b := annotations[i].Location, annotations[j].Location
+		if a == nil || b == nil {
+			return a != nil
+		}
+		if a.ChapterIndex != b.ChapterIndex {
+			return a.ChapterIndex < b.ChapterIndex
+		}
+		return a.Offset < b.Offset
+	})
+	return annotations, nil
+}
+
+// SearchAnnotations returns the highlights and bookmarks of all books whose
+// text, notes or title contain every word of the query, ignoring case and
+// accents, most recent first
+func SearchAnnotations(ctx context.Context, db *DB, libraryPath string, query string, limit int, offset int) (*AnnotationSearchResult, error) {
+	annotations, err := readAnnotations(ctx, db, "1")
+	if err != nil {
+		return nil, err
+	}
+	words := strings.Fields(fold(query))
+	matches := make([]Annotation, 0)
+	for _, a := range annotations {
+		text := fold(strings.Join([]string{a.HighlightedText, a.Notes, a.Title}, " "))
+		matched := true
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, a)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Timestamp > matches[j].Timestamp })
+
+	result := &AnnotationSearchResult{TotalNum: len(matches)}
+	if offset > 0 {
+		matches = matches[min(offset, len(matches)):]
+	}
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	locateAnnotations(db, libraryPath, matches)
+	result.Annotations = matches
+	return result, nil
+}
+
+// readAnnotations reads the highlights and bookmarks matching a condition on
+// the annotations table
+func readAnnotations(ctx context.Context, db *DB, where string, args ...any) ([]Annotation, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.id, a.book, b.title, a.format, a.user, a.timestamp, a.annot_data
+		FROM annotations a
+		JOIN books b ON a.book = b.id
+		WHERE a.annot_type IN ('highlight', 'bookmark') AND `+where+`
+		ORDER BY a.timestamp`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	annotations := make([]Annotation, 0)
+	for rows.Next() {
+		var a Annotation
+		var timestamp float64
+		var raw string
+		if err := rows.Scan(&a.ID, &a.BookID, &a.BookTitle, &a.Format, &a.User, &timestamp, &raw); err != nil {
+			return nil, err
+		}
+		var data annotationData
+		if err := json.Unmarshal([]byte(raw), &data); err != nil {
+			return nil, fmt.Errorf("annotation %d: %w", a.ID, err)
+		}
+		if data.Removed {
+			continue
+		}
+
+		a.Type = data.Type
+		a.Title = data.Title
+		a.HighlightedText = data.HighlightedText
+		a.Notes = data.Notes
+		a.Color = data.Style.Which
+		if a.Color == "" {
+			a.Color = data.Style.BackgroundColor
+		}
+		a.CFI = data.StartCFI
+		a.EndCFI = data.EndCFI
+		if a.Type == "bookmark" {
+			a.CFI = data.Pos
+		}
+		a.spine = -1
+		if data.SpineIndex != nil {
+			a.spine = *data.SpineIndex
+		}
+		a.Timestamp = data.Timestamp
+		if a.Timestamp == "" {
+			a.Timestamp = time.Unix(int64(timestamp), 0).UTC().Format(time.RFC3339)
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, rows.Err()
+}
+
+// locateAnnotations resolves the CFIs of EPUB annotations, opening each book
+// once. Annotations of books whose EPUB is missing or has changed are left
+// without a location.
+func locateAnnotations(db *DB, libraryPath string, annotations []Annotation) {
+	readers := make(map[int]*epubReader)
+	defer func() {
+		for _, r := range readers {
+			if r != nil {
+				r.Close()
+			}
+		}
+	}()
+
+	for i := range annotations {
+		a := &annotations[i]
+		if !strings.EqualFold(a.Format, "EPUB") || a.CFI == "" {
+			continue
+		}
+		r, ok := readers[a.BookID]
+		if !ok {
+			r, _ = openEPUBReader(db, libraryPath, a.BookID)
+			readers[a.BookID] = r
+		}
+		if r == nil {
+			continue
+		}
+		doc, start, end, err := r.resolveCFI(a.CFI, a.spine)
+		if err != nil {
+			continue
+		}
+		if a.EndCFI != "" {
+			if endDoc, pos, _, err := r.resolveCFI(a.EndCFI, a.spine); err == nil && endDoc == doc && pos > end {
+				end = pos
+			}
+		}
+		a.Location = doc.location(start, end)
+	}
+}
@@ -12,6 +12,10 @@ type BookDetails struct {
 	Identifiers    map[string]string   `json:"identifiers"`
 	UserCategories map[string][]string `json:"user_categories"`
 	CustomColumns  map[string]any      `json:"custom_columns"`
+	// Where each user stopped reading in Calibre's viewer, most recent first
+	ReadingPositions []ReadingPosition `json:"reading_positions"`
+	// Notes on the authors, series, publisher and tags of the book
+	Notes []Note `json:"notes"`
 }
 
 func GetBook(ctx context.Context, db *DB, id int) (*BookDetails, error) {
@@ -22,9 +26,12 @@ func GetBook(ctx context.Context, db *DB, id int) (*BookDetails, error) {
 	var language sql.NullString
 	var rating sql.NullInt32
 	var comments sql.NullString
+	var bookPath string
+	var hasCover bool
 	err := db.QueryRowContext(ctx, `
 		SELECT b.id, b.title, s.name, b.series_index, p.name, b.pubdate,
-		       b.isbn, l.lang_code, r.rating, c.text, b.timestamp, b.last_modified
+		       b.isbn, l.lang_code, r.rating, c.text, b.timestamp, b.last_modified,
+		       b.path, b.has_cover
 		FROM books b
 		LEFT JOIN books_series_link bsl ON b.id = bsl.book
 		LEFT JOIN series s ON bsl.series = s.id
@@ -38,7 +45,7 @@ func GetBook(ctx context.Context, db *DB, id int) (*BookDetails, error) {
 		WHERE b.id = ?
 	`, id).Scan(&book.ID, &book.Title, &series, &book.SeriesIndex, &publisher,
 		&book.PubDate, &book.Isbn, &language, &rating,
-		&comments, &book.Timestamp, &book.LastModified)
+		&comments, &book.Timestamp, &book.LastModified, &bookPath, &hasCover)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("book not found")
@@ -65,6 +72,7 @@ func GetBook(ctx context.Context, db *DB, id int) (*BookDetails, error) {
 	if !comments.Valid {
 		book.Comments = ""
 	}
+	book.Cover = coverPath(bookPath, hasCover)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("book not found")
@@ -106,6 +114,18 @@ func GetBook(ctx context.Context, db *DB, id int) (*BookDetails, error) {
 		return nil, err
 	}
 
+	// Get reading positions
+	book.ReadingPositions, err = getReadingPositions(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get notes on the authors, series, publisher and tags
+	book.Notes, err = getBookNotes(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+
 	// For now, leave UserCategories and CustomColumns empty
 	// They would require more complex queries into custom columns tables
 	book.UserCategories = make(map[string][]string)
@@ -135,3 +155,26 @@ func getIdentifiersForBook(db *DB, bookID int) (map[string]string, error) {
 	}
 	return identifiers, nil
 }
+
+// ListBookTitles returns the title of every book in the library, keyed by ID
+func ListBookTitles(ctx context.Context, db *DB) (map[int]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, title
+		FROM books
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var title string
+		if err := rows.Scan(&id, &title); err != nil {
+			return nil, err
+		}
+		titles[id] = title
+	}
+	return titles, rows.Err()
+}
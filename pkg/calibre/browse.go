@@ -0,0 +1,231 @@
+package calibre
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// browseQueries list the items of each browsable category with their ID,
+// sort key, link and number of books
+var browseQueries = map[string]string{
+	"authors": `
+		SELECT a.id, a.name, COALESCE(a.sort, ''), a.link, COUNT(l.book)
+		FROM authors a
+		LEFT JOIN books_authors_link l ON a.id = l.author
+		GROUP BY a.id
+	`,
+	"tags": `
+		SELECT t.id, t.name, '', '', COUNT(l.book)
+		FROM tags t
+		LEFT JOIN books_tags_link l ON t.id = l.tag
+		GROUP BY t.id
+	`,
+	"series": `
+		SELECT s.id, s.name, COALESCE(s.sort, ''), '', COUNT(l.book)
+		FROM series s
+		LEFT JOIN books_series_link l ON s.id = l.series
+		GROUP BY s.id
+	`,
+	"publishers": `
+		SELECT p.id, p.name, COALESCE(p.sort, ''), '', COUNT(l.book)
+		FROM publishers p
+		LEFT JOIN books_publishers_link l ON p.id = l.publisher
+		GROUP BY p.id
+	`,
+	"languages": `
+		SELECT g.id, g.lang_code, '', '', COUNT(l.book)
+		FROM languages g
+		LEFT JOIN books_languages_link l ON g.id = l.lang_code
+		GROUP BY g.id
+	`,
+	"formats": `
+		SELECT 0, format, '', '', COUNT(DISTINCT book)
+		FROM data
+		GROUP BY format
+	`,
+}
+
+// categoryBooksQueries select the IDs of the books of a category item
+var categoryBooksQueries = map[string]string{
+	"authors": `
+		SELECT b.id
+		FROM books b
+		JOIN books_authors_link l ON b.id = l.book
+		JOIN authors a ON l.author = a.id
+		WHERE a.name = ? COLLATE NOCASE
+	`,
+	"tags": `
+		SELECT b.id
+		FROM books b
+		JOIN books_tags_link l ON b.id = l.book
+		JOIN tags t ON l.tag = t.id
+		WHERE t.name = ? COLLATE NOCASE
+	`,
+	"series": `
+		SELECT b.id
+		FROM books b
+		JOIN books_series_link l ON b.id = l.book
+		JOIN series s ON l.series = s.id
+		WHERE s.name = ? COLLATE NOCASE
+	`,
+	"publishers": `
+		SELECT b.id
+		FROM books b
+		JOIN books_publishers_link l ON b.id = l.book
+		JOIN publishers p ON l.publisher = p.id
+		WHERE p.name = ? COLLATE NOCASE
+	`,
+	"languages": `
+		SELECT b.id
+		FROM books b
+		JOIN books_languages_link l ON b.id = l.book
+		JOIN languages g ON l.lang_code = g.id
+		WHERE g.lang_code = ? COLLATE NOCASE
+	`,
+	"formats": `
+		SELECT DISTINCT b.id
+		FROM books b
+		JOIN data d ON b.id = d.book
+		WHERE d.format = ? COLLATE NOCASE
+	`,
+}
+
+// BrowseCategories lists the categories accepted by BrowseCategory and
+// GetCategoryBooks
+var BrowseCategories = []string{"authors", "tags", "series", "publishers", "languages", "formats"}
+
+// CategoryItem is an author, tag, series, publisher, language or format,
+// along with the number of books that have it. Sort is the sort key Calibre
+// keeps for authors, series and publishers, Link the author's link, and Note
+// the text of the note Calibre keeps on the item.
+type CategoryItem struct {
+	Name  string `json:"name"`
+	Sort  string `json:"sort,omitempty"`
+	Link  string `json:"link,omitempty"`
+	Count int    `json:"count"`
+	Note  string `json:"note,omitempty"`
+
+	id int
+}
+
+type CategoryResult struct {
+	Items    []CategoryItem `json:"items"`
+	TotalNum int            `json:"total_num"`
+}
+
+// BrowseOptions selects and orders the items of a category. Items are sorted
+// by name (using the sort key where there is one) unless SortBy is "count",
+// which puts the items with the most books first.
+type BrowseOptions struct {
+	Prefix string
+	SortBy string
+	Limit  int
+	Offset int
+}
+
+// BrowseCategory lists the items of a category. The prefix matches the start
+// of the name or the sort key, ignoring case and accents.
+func BrowseCategory(ctx context.Context, db *DB, category string, opts BrowseOptions) (*CategoryResult, error) {
+	query, ok := browseQueries[category]
+	if !ok {
+		return nil, fmt.Errorf("unknown category %q, must be one of %s", category, strings.Join(BrowseCategories, ", "))
+	}
+	if opts.SortBy != "" && opts.SortBy != "name" && opts.SortBy != "count" {
+		return nil, fmt.Errorf("unknown sort %q, must be name or count", opts.SortBy)
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prefix := fold(strings.TrimSpace(opts.Prefix))
+	items := []CategoryItem{}
+	for rows.Next() {
+		var item CategoryItem
+		if err := rows.Scan(&item.id, &item.Name, &item.Sort, &item.Link, &item.Count); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(fold(item.Name), prefix) || (item.Sort != "" && strings.HasPrefix(fold(item.Sort), prefix)) {
+			items = append(items, item)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortKey := func(item CategoryItem) string {
+		if item.Sort != "" {
+			return fold(item.Sort)
+		}
+		return fold(item.Name)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if opts.SortBy == "count" && items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return sortKey(items[i]) < sortKey(items[j])
+	})
+
+	total := len(items)
+	items = paginate(items, opts.Limit, opts.Offset)
+
+	ids := make([]int, len(items))
+	for i, item := range items {
+		ids[i] = item.id
+	}
+	notes, err := readNotes(ctx, db, category, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		items[i].Note = notes[item.id].Text
+	}
+	return &CategoryResult{Items: items, TotalNum: total}, nil
+}
+
+// GetCategoryBooks returns the books of one item of a category, such as the
+// books of an author or with a tag, sorted by title
+func GetCategoryBooks(ctx context.Context, db *DB, category string, name string, limit int, offset int) (*SearchResult, error) {
+	query, ok := categoryBooksQueries[category]
+	if !ok {
+		return nil, fmt.Errorf("unknown category %q, must be one of %s", category, strings.Join(BrowseCategories, ", "))
+	}
+
+	rows, err := db.QueryContext(ctx, query+" ORDER BY b.sort, b.id", strings.TrimSpace(name))
+	if err != nil {
+		return nil, err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	total := len(ids)
+	ids = paginate(ids, limit, offset)
+	books := make([]Book, 0, len(ids))
+	for _, id := range ids {
+		book, err := GetBook(ctx, db, id)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book.Book)
+	}
+	return &SearchResult{Books: books, TotalNum: total}, nil
+}
+
+func paginate[T any](items []T, limit int, offset int) []T {
+	if offset > 0 {
+		if offset >= len(items) {
+			return items[:0]
+		}
+		items = items[offset:]
+	}
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,151 @@
+package calibre
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Operations of bulk edits
+const (
+	BulkAddTag       = "add_tag"
+	BulkRemoveTag    = "remove_tag"
+	BulkRenameTag    = "rename_tag"
+	BulkMergeAuthors = "merge_authors"
+	BulkSetSeries    = "set_series"
+)
+
+// ErrPlanOutdated is returned when applying a plan to books that changed
+// since it was made
+var ErrPlanOutdated = errors.New("the books changed since the plan was made, make a new plan")
+
+// BulkEdit is an operation applied to every book matching a search query, or
+// to the whole library when the query is empty. Value is the tag to add or
+// remove, the new tag name, the author to keep or the series to set. From
+// lists the tags to rename to Value, or the authors to merge into Value.
+type BulkEdit struct {
+	Query     string   `json:"query"`
+	Operation string   `json:"operation"`
+	Value     string   `json:"value"`
+	From      []string `json:"from,omitempty"`
+}
+
+// EditPlan holds the edits of a bulk edit and the changes they made when the
+// plan was made, so that applying it makes exactly these changes
+type EditPlan struct {
+	Edits   []BookEdit   `json:"edits"`
+	Changes []BookChange `json:"changes"`
+}
+
+// PlanBulkEdit works out the edits of a bulk operation and runs them without
+// committing them, to report the changes they make
+func PlanBulkEdit(ctx context.Context, db *DB, bulk BulkEdit) (*EditPlan, error) {
+	value := strings.TrimSpace(bulk.Value)
+	switch bulk.Operation {
+	case BulkAddTag, BulkRemoveTag, BulkSetSeries:
+	case BulkRenameTag, BulkMergeAuthors:
+		if len(bulk.From) == 0 {
+			return nil, fmt.Errorf("%s needs the names to replace in from", bulk.Operation)
+		}
+	default:
+		return nil, fmt.Errorf("unknown operation %q, expected %s, %s, %s, %s or %s", bulk.Operation,
+			BulkAddTag, BulkRemoveTag, BulkRenameTag, BulkMergeAuthors, BulkSetSeries)
+	}
+	if value == "" && bulk.Operation != BulkSetSeries {
+		return nil, fmt.Errorf("%s needs a value", bulk.Operation)
+	}
+
+	results, err := Search(ctx, db, bulk.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	from := make(map[string]bool, len(bulk.From))
+	for _, name := range bulk.From {
+		from[fold(strings.TrimSpace(name))] = true
+	}
+
+	var edits []BookEdit
+	seen := make(map[int]bool)
+	for _, book := range results.Books {
+		if seen[book.ID] {
+			continue
+		}
+		seen[book.ID] = true
+
+		var field FieldValue
+		switch bulk.Operation {
+		case BulkAddTag, BulkRemoveTag, BulkRenameTag:
+			tags, err := getTagsForBook(db, book.ID)
+			if err != nil {
+				return nil, err
+			}
+			switch bulk.Operation {
+			case BulkAddTag:
+				tags = append(tags, value)
+			case BulkRemoveTag:
+				tags = replaceNames(tags, map[string]bool{fold(value): true}, "")
+			case BulkRenameTag:
+				tags = replaceNames(tags, from, value)
+			}
+			field = FieldValue{FieldTags, tags}
+		case BulkMergeAuthors:
+			authors, err := getAuthorsForBook(db, book.ID)
+			if err != nil {
+				return nil, err
+			}
+			field = FieldValue{FieldAuthors, replaceNames(authors, from, value)}
+		case BulkSetSeries:
+			field = FieldValue{FieldSeries, value}
+		}
+		edits = append(edits, BookEdit{BookID: book.ID, Fields: []FieldValue{field}})
+	}
+
+	set, err := EditBooks(ctx, db, edits, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the edits of the books that change
+	changed := make(map[int]bool, len(set.Changes))
+	for _, change := range set.Changes {
+		changed[change.BookID] = true
+	}
+	plan := &EditPlan{Edits: []BookEdit{}, Changes: set.Changes}
+	for _, edit := range edits {
+		if changed[edit.BookID] {
+			plan.Edits = append(plan.Edits, edit)
+		}
+	}
+	return plan, nil
+}
+
+// ApplyEditPlan applies the edits of a plan in a single transaction. If they
+// don't make the planned changes, because the books changed in the meantime,
+// nothing is written and ErrPlanOutdated is returned.
+func ApplyEditPlan(ctx context.Context, db *DB, plan *EditPlan) (*ChangeSet, error) {
+	return editBooks(ctx, db, plan.Edits, editOptions{commit: true, verify: plan.verify})
+}
+
+func (plan *EditPlan) verify(changes []BookChange) error {
+	if !reflect.DeepEqual(changes, plan.Changes) {
+		return ErrPlanOutdated
+	}
+	return nil
+}
+
+// replaceNames replaces the names found in from, ignoring case and accents,
+// with name, or removes them if name is empty
+func replaceNames(names []string, from map[string]bool, name string) []string {
+	replaced := make([]string, 0, len(names))
+	for _, n := range names {
+		if !from[fold(n)] {
+			replaced = append(replaced, n)
+		} else if name != "" {
+			replaced = append(replaced, name)
+		}
+	}
+	return replaced
+}
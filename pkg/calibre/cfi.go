@@ -0,0 +1,731 @@
+package calibre
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const (
+	// contextChars is how many characters of the surrounding paragraph are
+	// kept on each side of a location
+	contextChars = 300
+	// defaultPassageLength is how many characters GetPassageByCFI returns
+	// from a position by default
+	defaultPassageLength = 1000
+)
+
+// Location is a position in the text of an EPUB chapter. Offset counts the
+// characters before it in the chapter text as GetEPUBChapterContent returns
+// it, and Context is the surrounding paragraph.
+type Location struct {
+	ChapterIndex int    `json:"chapter_index"`
+	ChapterTitle string `json:"chapter_title"`
+	Offset       int    `json:"offset"`
+	Context      string `json:"context,omitempty"`
+}
+
+// cfiStep is a step of a CFI path: even indices select child elements and
+// odd ones the text between them, counting from 1
+type cfiStep struct {
+	index int
+	id    string
+}
+
+// cfiPath locates a node from the document node of a chapter, and a
+// character offset in UTF-16 code units for text nodes, or -1
+type cfiPath struct {
+	steps  []cfiStep
+	offset int
+}
+
+// cfi is a parsed EPUB canonical fragment identifier. Spine is the spine
+// index of the chapter, or -1 when the CFI is relative to a chapter as in
+// Calibre highlights. End is set for ranges.
+type cfi struct {
+	spine int
+	start cfiPath
+	end   *cfiPath
+}
+
+// parseCFI parses the CFIs of the EPUB specification, such as
+// epubcfi(/6/4!/4/10/3:12), and the forms used by Calibre's viewer: positions
+// such as epubcfi(/4/2/4/10/1:12), whose first step is the spine item, and
+// highlights such as /2/4/10/1:12, relative to a chapter. Steps of Calibre
+// CFIs start at the document node, and the ones after the indirection step of
+// the specification at the root element.
+func parseCFI(value string) (*cfi, error) {
+	s := strings.TrimSpace(value)
+	wrapped := strings.HasPrefix(s, "epubcfi(") && strings.HasSuffix(s, ")")
+	if wrapped {
+		s = s[len("epubcfi(") : len(s)-1]
+	}
+	parts := splitCFI(s, ',')
+	if len(parts) != 1 && len(parts) != 3 {
+		return nil, fmt.Errorf("invalid CFI %q", value)
+	}
+
+	c := &cfi{spine: -1}
+	var parent cfiPath
+	var err error
+	if indirection := splitCFI(parts[0], '!'); len(indirection) == 2 {
+		spine, err := parseCFIPath(indirection[0])
+		if err != nil || len(spine.steps) < 2 {
+			return nil, fmt.Errorf("invalid CFI %q", value)
+		}
+		c.spine = spine.steps[1].index/2 - 1
+		if parent, err = parseCFIPath(indirection[1]); err != nil {
+			return nil, fmt.Errorf("invalid CFI %q: %w", value, err)
+		}
+		parent.steps = append([]cfiStep{{index: 2}}, parent.steps...)
+	} else if len(indirection) == 1 {
+		if parent, err = parseCFIPath(parts[0]); err != nil {
+			return nil, fmt.Errorf("invalid CFI %q: %w", value, err)
+		}
+		if wrapped {
+			if len(parent.steps) == 0 {
+				return nil, fmt.Errorf("invalid CFI %q", value)
+			}
+			c.spine = parent.steps[0].index/2 - 1
+			parent.steps = parent.steps[1:]
+		}
+	} else {
+		return nil, fmt.Errorf("invalid CFI %q", value)
+	}
+	if c.spine < -1 {
+		return nil, fmt.Errorf("invalid CFI %q", value)
+	}
+
+	if len(parts) == 1 {
+		c.start = parent
+		return c, nil
+	}
+	if parent.offset >= 0 {
+		return nil, fmt.Errorf("invalid CFI %q", value)
+	}
+	for i, part := range parts[1:] {
+		local, err := parseCFIPath(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CFI %q: %w", value, err)
+		}
+		local.steps = append(append([]cfiStep{}, parent.steps...), local.steps...)
+		if i == 0 {
+			c.start = local
+		} else {
+			c.end = &local
+		}
+	}
+	return c, nil
+}
+
+// parseCFIPath parses steps such as /4[body01]/10/3 with an optional
+// character offset, ignoring assertions and spatial or temporal offsets
+func parseCFIPath(s string) (cfiPath, error) {
+	p := cfiPath{offset: -1}
+	number := func(i int) (int, int, error) {
+		j := i
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		n, err := strconv.Atoi(s[i:j])
+		if err != nil {
+			return 0, j, fmt.Errorf("expected a number at %q", s[i:])
+		}
+		return n, j, nil
+	}
+
+	i := 0
+	for i < len(s) && s[i] == '/' {
+		n, j, err := number(i + 1)
+		if err != nil {
+			return p, err
+		}
+		step := cfiStep{index: n}
+		if j < len(s) && s[j] == '[' {
+			var assertion string
+			assertion, j = readCFIAssertion(s, j)
+			step.id, _, _ = strings.Cut(assertion, ";")
+		}
+		p.steps = append(p.steps, step)
+		i = j
+	}
+	if i < len(s) && s[i] == ':' {
+		n, j, err := number(i + 1)
+		if err != nil {
+			return p, err
+		}
+		p.offset = n
+		i = j
+	}
+	for i < len(s) {
+		switch s[i] {
+		case '[':
+			_, i = readCFIAssertion(s, i)
+		case '~', '@':
+			i++
+			for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.' || s[i] == ':') {
+				i++
+			}
+		default:
+			return p, fmt.Errorf("unexpected %q", s[i:])
+		}
+	}
+	return p, nil
+}
+
+// readCFIAssertion reads the bracketed assertion starting at s[i], returning
+// it unescaped along with the index following it
+func readCFIAssertion(s string, i int) (string, int) {
+	var b strings.Builder
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '^':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case ']':
+			return b.String(), i + 1
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), i
+}
+
+// splitCFI splits a CFI at the separators outside of assertions
+func splitCFI(s string, sep byte) []string {
+	var parts []string
+	start, depth := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '^':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cfiNode is an element or text node of a chapter. The children of an
+// element alternate between text, which may be empty, and elements, so that
+// the child with CFI index i is children[i-1]. Start and end are the range
+// of the node in the chapter text, and the offsets of a text node map each
+// UTF-16 offset within it to a position in the chapter text, with written
+// telling the characters that are in the text rather than collapsed.
+type cfiNode struct {
+	name     string
+	id       string
+	parent   *cfiNode
+	index    int
+	children []*cfiNode
+	text     string
+	label    string
+	offsets  []int
+	written  []bool
+	start    int
+	end      int
+}
+
+// chapterDocument is a chapter of an EPUB parsed to resolve CFIs in it, along
+// with its text. Paragraphs and other blocks are on separate lines of the
+// text, with their spaces collapsed, and each image is replaced with the
+// label of its figure on a line of its own.
+type chapterDocument struct {
+	index int
+	title string
+	root  *cfiNode
+	ids   map[string]*cfiNode
+	text  []rune
+	// texts are the text nodes that are not hidden, in document order
+	texts []*cfiNode
+	// figures are the image elements whose label is in the text
+	figures []*cfiNode
+}
+
+// blockElements start a new line in the chapter text
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "body": true, "br": true,
+	"caption": true, "dd": true, "div": true, "dl": true, "dt": true, "figcaption": true, "figure": true,
+	"footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "header": true,
+	"hr": true, "li": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true, "table": true,
+	"td": true, "th": true, "tr": true, "ul": true,
+}
+
+// hiddenElements hold no text of the chapter
+var hiddenElements = map[string]bool{"head": true, "script": true, "style": true}
+
+// parseChapterDocument parses the XHTML of a chapter
+func parseChapterDocument(index int, data []byte) (*chapterDocument, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+
+	doc := &chapterDocument{
+		index: index,
+		title: extractTitleFromHTML(string(data)),
+		root:  &cfiNode{name: "#document"},
+		ids:   make(map[string]*cfiNode),
+	}
+	if doc.title == "" {
+		doc.title = fmt.Sprintf("Chapter %d", index+1)
+	}
+	// The images are found by the same parse of the markup, so their offsets
+	// tell which elements they are
+	images := parseChapterImages(string(data))
+	stack := []*cfiNode{doc.root}
+	for {
+		offset := int(d.InputOffset())
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse chapter: %w", err)
+		}
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &cfiNode{name: strings.ToLower(t.Name.Local)}
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "id" {
+					node.id = attr.Value
+					doc.ids[attr.Value] = node
+				}
+			}
+			if len(images) > 0 && images[0].start == offset {
+				node.label = images[0].placeholder()
+				images = images[1:]
+			}
+			if n := len(parent.children); n == 0 || parent.children[n-1].name != "" {
+				parent.appendChild(&cfiNode{})
+			}
+			parent.appendChild(node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				closeCFINode(parent)
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if n := len(parent.children); n > 0 && parent.children[n-1].name == "" {
+				parent.children[n-1].text += string(t)
+			} else {
+				parent.appendChild(&cfiNode{text: string(t)})
+			}
+		}
+	}
+	for _, node := range stack {
+		closeCFINode(node)
+	}
+
+	var b chapterTextBuilder
+	b.walk(doc.root, false)
+	doc.text = b.text
+	doc.texts = b.texts
+	doc.figures = b.figures
+	return doc, nil
+}
+
+func (node *cfiNode) appendChild(child *cfiNode) {
+	child.parent = node
+	node.children = append(node.children, child)
+	child.index = len(node.children)
+}
+
+// closeCFINode ends the children of an element with a text node
+func closeCFINode(node *cfiNode) {
+	if n := len(node.children); n == 0 || node.children[n-1].name != "" {
+		node.appendChild(&cfiNode{})
+	}
+}
+
+// chapterTextBuilder collects the text of a chapter, collapsing spaces and
+// putting blocks on their own lines
+type chapterTextBuilder struct {
+	text    []rune
+	texts   []*cfiNode
+	figures []*cfiNode
+	space   bool
+	newline bool
+}
+
+func (b *chapterTextBuilder) walk(node *cfiNode, hidden bool) {
+	if node.name == "" {
+		node.offsets = make([]int, 0, len(node.text)+1)
+		node.written = make([]bool, 0, len(node.text))
+		node.start = len(b.text)
+		for _, r := range node.text {
+			pos, written := len(b.text), false
+			if !hidden {
+				pos, written = b.write(r)
+			}
+			node.offsets = append(node.offsets, pos)
+			node.written = append(node.written, written)
+			if r >= 0x10000 {
+				node.offsets = append(node.offsets, pos)
+				node.written = append(node.written, false)
+			}
+		}
+		node.offsets = append(node.offsets, len(b.text))
+		node.end = len(b.text)
+		if !hidden {
+			b.texts = append(b.texts, node)
+		}
+		return
+	}
+
+	hidden = hidden || hiddenElements[node.name]
+	if node.label != "" && !hidden {
+		b.breakLine()
+		node.start = len(b.text)
+		for _, r := range node.label {
+			b.write(r)
+		}
+		// The drawing of an SVG has no text of the chapter, but its text
+		// nodes still map CFIs to the end of the label
+		for _, child := range node.children {
+			b.walk(child, true)
+		}
+		node.end = len(b.text)
+		b.figures = append(b.figures, node)
+		b.breakLine()
+		return
+	}
+	block := blockElements[node.name] && !hidden
+	if block {
+		b.breakLine()
+	}
+	node.start = len(b.text)
+	for _, child := range node.children {
+		b.walk(child, hidden)
+	}
+	node.end = len(b.text)
+	if block {
+		b.breakLine()
+	}
+}
+
+// write adds a character to the text, returning its position and whether it
+// was written rather than collapsed into a space
+func (b *chapterTextBuilder) write(r rune) (int, bool) {
+	if unicode.IsSpace(r) {
+		if len(b.text) > 0 && !b.newline {
+			b.space = true
+		}
+		return len(b.text), false
+	}
+	if b.newline {
+		b.text = append(b.text, '\n')
+	} else if b.space {
+		b.text = append(b.text, ' ')
+	}
+	b.space, b.newline = false, false
+	b.text = append(b.text, r)
+	return len(b.text) - 1, true
+}
+
+func (b *chapterTextBuilder) breakLine() {
+	if len(b.text) > 0 {
+		b.newline, b.space = true, false
+	}
+}
+
+// resolve returns the position in the chapter text of a CFI path. Steps with
+// an id that does not match the node at their index are resolved by id.
+func (doc *chapterDocument) resolve(p cfiPath) (int, error) {
+	node := doc.root
+	for _, step := range p.steps {
+		if node.name == "" || step.index < 1 || step.index > len(node.children) {
+			return 0, fmt.Errorf("CFI does not match chapter %d", doc.index)
+		}
+		child := node.children[step.index-1]
+		if step.id != "" && child.id != step.id {
+			if byID, ok := doc.ids[step.id]; ok {
+				child = byID
+			}
+		}
+		node = child
+	}
+	if node.name != "" || p.offset <= 0 || len(node.offsets) == 0 {
+		return node.start, nil
+	}
+	return node.offsets[min(p.offset, len(node.offsets)-1)], nil
+}
+
+// location returns the location of a range of the chapter text, with the
+// paragraphs it covers as context
+func (doc *chapterDocument) location(start int, end int) *Location {
+	start = max(0, min(start, len(doc.text)))
+	// Positions at the start of an element fall on the space before it
+	for start < len(doc.text) && (doc.text[start] == '\n' || doc.text[start] == ' ') {
+		start++
+	}
+	end = max(start, min(end, len(doc.text)))
+
+	from, to := start, end
+	for from > 0 && doc.text[from-1] != '\n' && start-from < contextChars {
+		from--
+	}
+	for to < len(doc.text) && doc.text[to] != '\n' && to-end < contextChars {
+		to++
+	}
+	context := strings.TrimSpace(string(doc.text[from:to]))
+	if from > 0 && doc.text[from-1] != '\n' {
+		context = "…" + context
+	}
+	if to < len(doc.text) && doc.text[to] != '\n' {
+		context += "…"
+	}
+	return &Location{ChapterIndex: doc.index, ChapterTitle: doc.title, Offset: start, Context: context}
+}
+
+// pointAt returns the text node and UTF-16 offset of the character at a
+// position of the chapter text, or of the next character when the position
+// falls on a space or line break
+func (doc *chapterDocument) pointAt(pos int) (*cfiNode, int, bool) {
+	if len(doc.texts) == 0 {
+		return nil, 0, false
+	}
+	first := sort.Search(len(doc.texts), func(i int) bool { return doc.texts[i].end > pos })
+	for _, node := range doc.texts[first:] {
+		for i, written := range node.written {
+			if written && node.offsets[i] >= pos {
+				return node, i, true
+			}
+		}
+	}
+	last := doc.texts[len(doc.texts)-1]
+	return last, len(last.offsets) - 1, true
+}
+
+// figureAt returns the image element whose label is at a position of the
+// chapter text
+func (doc *chapterDocument) figureAt(pos int) (*cfiNode, bool) {
+	for _, node := range doc.figures {
+		if node.start <= pos && pos < node.end {
+			return node, true
+		}
+	}
+	return nil, false
+}
+
+// cfiSteps returns the steps from the document node to a node
+func (node *cfiNode) cfiSteps() []string {
+	var steps []string
+	for n := node; n.parent != nil; n = n.parent {
+		step := "/" + strconv.Itoa(n.index)
+		if n.id != "" {
+			step += "[" + escapeCFI(n.id) + "]"
+		}
+		steps = append(steps, step)
+	}
+	slices.Reverse(steps)
+	return steps
+}
+
+// escapeCFI escapes the characters with a meaning in CFI assertions
+func escapeCFI(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("^[](),;=", r) {
+			b.WriteByte('^')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// spineStep returns the first step of the CFIs of the chapter, which selects
+// its spine item
+func (doc *chapterDocument) spineStep() string {
+	return "/" + strconv.Itoa((doc.index+1)*2)
+}
+
+// cfiPoint returns the CFI of a position of the chapter text, in the form
+// Calibre's viewer uses for bookmarks and reading positions
+func (doc *chapterDocument) cfiPoint(pos int) string {
+	if figure, ok := doc.figureAt(pos); ok {
+		return "epubcfi(" + doc.spineStep() + strings.Join(figure.cfiSteps(), "") + ")"
+	}
+	node, offset, ok := doc.pointAt(pos)
+	if !ok {
+		return "epubcfi(" + doc.spineStep() + ")"
+	}
+	return fmt.Sprintf("epubcfi(%s%s:%d)", doc.spineStep(), strings.Join(node.cfiSteps(), ""), offset)
+}
+
+// cfiRange returns the range CFI of the characters of the chapter text from
+// start to end, with the path they share as parent. Text in the label of a
+// figure has no range and gets the CFI of its image element.
+func (doc *chapterDocument) cfiRange(start int, end int) string {
+	if _, ok := doc.figureAt(start); ok {
+		return doc.cfiPoint(start)
+	}
+	startNode, startOffset, ok := doc.pointAt(start)
+	if !ok || end <= start {
+		return doc.cfiPoint(start)
+	}
+	endNode, endOffset, _ := doc.pointAt(end - 1)
+	// Ranges end after their last character, which may be a surrogate pair
+	endOffset++
+	for endOffset < len(endNode.written) && !endNode.written[endOffset] && endNode.offsets[endOffset] == endNode.offsets[endOffset-1] {
+		endOffset++
+	}
+
+	a, b := startNode.cfiSteps(), endNode.cfiSteps()
+	common := 0
+	for common < len(a)-1 && common < len(b)-1 && a[common] == b[common] {
+		common++
+	}
+	return fmt.Sprintf("epubcfi(%s%s,%s:%d,%s:%d)", doc.spineStep(), strings.Join(a[:common], ""),
+		strings.Join(a[common:], ""), startOffset, strings.Join(b[common:], ""), endOffset)
+}
+
+// epubReader reads the chapters of an EPUB by spine index, parsing each one
+// once
+type epubReader struct {
+	r        *zip.ReadCloser
+	pkg      *Package
+	opfPath  string
+	chapters map[int]*chapterDocument
+}
+
+func openEPUBReader(db *DB, libraryPath string, bookID int) (*epubReader, error) {
+	epubPath, err := getEPUBPath(db, libraryPath, bookID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	pkg, opfPath, err := readPackage(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &epubReader{r: r, pkg: pkg, opfPath: opfPath, chapters: make(map[int]*chapterDocument)}, nil
+}
+
+func (e *epubReader) Close() error {
+	return e.r.Close()
+}
+
+// chapter returns the chapter at a spine index
+func (e *epubReader) chapter(index int) (*chapterDocument, error) {
+	if doc, ok := e.chapters[index]; ok {
+		return doc, nil
+	}
+	if index < 0 || index >= len(e.pkg.Spine.Itemrefs) {
+		return nil, fmt.Errorf("chapter index out of range")
+	}
+	idref := e.pkg.Spine.Itemrefs[index].Idref
+	for _, item := range e.pkg.Manifest.Items {
+		if item.Id != idref {
+			continue
+		}
+		_, data, err := readChapterFile(e.r, e.opfPath, item.Href)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := parseChapterDocument(index, data)
+		if err != nil {
+			return nil, err
+		}
+		e.chapters[index] = doc
+		return doc, nil
+	}
+	return nil, fmt.Errorf("chapter %d is not in the manifest", index)
+}
+
+// resolveCFI returns the chapter a CFI points to and the range of its text,
+// which is empty unless the CFI is a range. Spine is the chapter of CFIs
+// relative to one.
+func (e *epubReader) resolveCFI(value string, spine int) (*chapterDocument, int, int, error) {
+	c, err := parseCFI(value)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if c.spine >= 0 {
+		spine = c.spine
+	}
+	if spine < 0 {
+		return nil, 0, 0, fmt.Errorf("CFI %q is relative to a chapter, whose index is needed", value)
+	}
+	doc, err := e.chapter(spine)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	start, err := doc.resolve(c.start)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	end := start
+	if c.end != nil {
+		if end, err = doc.resolve(*c.end); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	return doc, start, end, nil
+}
+
+// Passage is the text of an EPUB chapter a CFI points to
+type Passage struct {
+	BookID   int       `json:"book_id"`
+	CFI      string    `json:"cfi"`
+	Location *Location `json:"location"`
+	Text     string    `json:"text"`
+}
+
+// GetPassageByCFI returns the text a CFI points to in the EPUB of a book: the
+// text of a range CFI, or up to length characters of the chapter from the
+// position of others. Spine is the chapter index of CFIs relative to a
+// chapter, such as the ones of Calibre highlights, and -1 otherwise.
+func GetPassageByCFI(db *DB, libraryPath string, bookID int, value string, spine int, length int) (*Passage, error) {
+	if length <= 0 {
+		length = defaultPassageLength
+	}
+	r, err := openEPUBReader(db, libraryPath, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	doc, start, end, err := r.resolveCFI(value, spine)
+	if err != nil {
+		return nil, err
+	}
+	passage := &Passage{BookID: bookID, CFI: value, Location: doc.location(start, end)}
+	start = passage.Location.Offset
+	if end <= start {
+		end = min(start+length, len(doc.text))
+	}
+	passage.Text = string(doc.text[start:end])
+	return passage, nil
+}
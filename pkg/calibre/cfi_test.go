@@ -0,0 +1,61 @@
+package calibre
+
+import "testing"
+
+func TestResolveCFIInSVG(t *testing.T) {
+	doc, err := parseChapterDocument(0, []byte(`<html><head><title>One</title></head>`+
+		`<body><p>Hello</p><svg> <image href="a.jpg"/> <text>drawn</text></svg><p>World</p></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello\n[Figure 1]\nWorld"; string(doc.text) != want {
+		t.Fatalf("text = %q, want %q", string(doc.text), want)
+	}
+
+	tests := []struct {
+		name string
+		cfi  string
+		want int
+	}{
+		{"space in the drawing", "/2/4/4/1:1", 16},
+		{"text of the drawing", "/2/4/4/4/1:3", 16},
+		// Elements start on the line break before them
+		{"drawing", "/2/4/4", 5},
+		{"text after the drawing", "/2/4/6/1:2", 19},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCFI(tt.cfi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := doc.resolve(c.start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolve(%s) = %d, want %d", tt.cfi, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChapterTextFigures(t *testing.T) {
+	doc, err := parseChapterDocument(0, []byte(`<html><body>`+
+		`<figure><img src="a.png" alt="A box"/><figcaption>The box</figcaption></figure>`+
+		`<figure><figcaption>Caption first</figcaption><img src="b.png"/></figure>`+
+		`<p><img src="c.png" alt="A ring"/></p>`+
+		`</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[Figure 1]\nThe box\nCaption first\n[Figure 2]\n[Figure 3: A ring]"
+	if string(doc.text) != want {
+		t.Errorf("text = %q, want %q", string(doc.text), want)
+	}
+
+	images := parseChapterImages(`<figure><figcaption>Caption first</figcaption><img src="b.png"/></figure>`)
+	if len(images) != 1 || images[0].Caption != "Caption first" {
+		t.Errorf("images = %+v, want one with the caption placed before it", images)
+	}
+}
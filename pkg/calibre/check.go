@@ -0,0 +1,215 @@
+package calibre
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of library issues found by CheckLibrary
+const (
+	IssueIntegrity     = "integrity"
+	IssueMissingFolder = "missing_folder"
+	IssueMissingFile   = "missing_file"
+	IssueSizeMismatch  = "size_mismatch"
+	IssueOrphanFolder  = "orphan_folder"
+	IssueMissingCover  = "missing_cover"
+	IssueUnknownCover  = "unknown_cover"
+)
+
+// LibraryIssue is a problem found in the library. Path is relative to the
+// library, and BookID is zero for problems that concern no book.
+type LibraryIssue struct {
+	Kind    string `json:"kind"`
+	BookID  int    `json:"book_id,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// LibraryReport lists the problems found by CheckLibrary
+type LibraryReport struct {
+	Books          int            `json:"books"`
+	Files          int            `json:"files"`
+	IntegrityCheck []string       `json:"integrity_check"`
+	Issues         []LibraryIssue `json:"issues"`
+	Counts         map[string]int `json:"counts"`
+}
+
+// OK reports whether no problem was found
+func (r *LibraryReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+func (r *LibraryReport) add(issue LibraryIssue) {
+	r.Issues = append(r.Issues, issue)
+	r.Counts[issue.Kind]++
+}
+
+// CheckLibrary compares metadata.db with the files of the library. It runs
+// SQLite's integrity check, looks for missing book folders and format files,
+// sizes that differ from the recorded ones, covers that don't match has_cover,
+// and book folders that no book refers to.
+func CheckLibrary(ctx context.Context, db *DB, libraryPath string) (*LibraryReport, error) {
+	report := &LibraryReport{
+		IntegrityCheck: []string{},
+		Issues:         []LibraryIssue{},
+		Counts:         make(map[string]int),
+	}
+
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.IntegrityCheck = append(report.IntegrityCheck, result)
+		if result != "ok" {
+			report.add(LibraryIssue{Kind: IssueIntegrity, Path: "metadata.db", Message: result})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Check the folder and cover of each book
+	rows, err = db.QueryContext(ctx, `
+		SELECT id, path, has_cover
+		FROM books
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	bookPaths := make(map[string]bool)
+	missingFolders := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		var bookPath string
+		var hasCover bool
+		if err := rows.Scan(&id, &bookPath, &hasCover); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.Books++
+		bookPaths[filepath.Clean(filepath.FromSlash(bookPath))] = true
+
+		info, err := os.Stat(filepath.Join(libraryPath, bookPath))
+		if err != nil || !info.IsDir() {
+			missingFolders[id] = true
+			report.add(LibraryIssue{Kind: IssueMissingFolder, BookID: id, Path: bookPath, Message: "book folder does not exist"})
+			continue
+		}
+
+		_, err = os.Stat(filepath.Join(libraryPath, bookPath, coverFileName))
+		switch {
+		case hasCover && errors.Is(err, os.ErrNotExist):
+			report.add(LibraryIssue{Kind: IssueMissingCover, BookID: id, Path: coverPath(bookPath, true),
+				Message: "has_cover is set but the cover file does not exist"})
+		case !hasCover && err == nil:
+			report.add(LibraryIssue{Kind: IssueUnknownCover, BookID: id, Path: coverPath(bookPath, true),
+				Message: "a cover file exists but has_cover is not set"})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Check the format files
+	rows, err = db.QueryContext(ctx, `
+		SELECT b.id, b.path, d.name, d.format, COALESCE(d.uncompressed_size, 0)
+		FROM data d
+		JOIN books b ON d.book = b.id
+		ORDER BY b.id, d.format
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		var bookPath, name, format string
+		var size int64
+		if err := rows.Scan(&id, &bookPath, &name, &format, &size); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.Files++
+		if missingFolders[id] {
+			continue
+		}
+
+		filePath := filepath.Join(bookPath, name+"."+strings.ToLower(format))
+		info, err := os.Stat(filepath.Join(libraryPath, filePath))
+		if err != nil {
+			report.add(LibraryIssue{Kind: IssueMissingFile, BookID: id, Path: filePath,
+				Message: fmt.Sprintf("%s file does not exist", format)})
+			continue
+		}
+		if info.Size() != size {
+			report.add(LibraryIssue{Kind: IssueSizeMismatch, BookID: id, Path: filePath,
+				Message: fmt.Sprintf("%s file is %d bytes, %d recorded", format, info.Size(), size)})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	orphans, err := findOrphanFolders(libraryPath, bookPaths)
+	if err != nil {
+		return nil, err
+	}
+	for _, orphan := range orphans {
+		report.add(LibraryIssue{Kind: IssueOrphanFolder, Path: filepath.ToSlash(orphan), Message: "no book refers to this folder"})
+	}
+
+	return report, nil
+}
+
+// findOrphanFolders lists the book folders, laid out by Calibre as
+// Author/Title (id), that no book refers to. Author folders without any book
+// folder are listed too.
+func findOrphanFolders(libraryPath string, bookPaths map[string]bool) ([]string, error) {
+	authors, err := os.ReadDir(libraryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []string
+	for _, author := range authors {
+		// Hidden folders hold Calibre's trash, notes and other data
+		if !author.IsDir() || strings.HasPrefix(author.Name(), ".") {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(libraryPath, author.Name()))
+		if err != nil {
+			return nil, err
+		}
+		hasBook := false
+		var authorOrphans []string
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			bookPath := filepath.Join(author.Name(), entry.Name())
+			if bookPaths[bookPath] {
+				hasBook = true
+			} else {
+				authorOrphans = append(authorOrphans, bookPath)
+			}
+		}
+		if !hasBook && len(authorOrphans) == 0 {
+			orphans = append(orphans, author.Name())
+		}
+		orphans = append(orphans, authorOrphans...)
+	}
+	return orphans, nil
+}
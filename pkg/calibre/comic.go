@@ -0,0 +1,329 @@
+package calibre
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// comicFormats lists the comic book archive formats, in order of preference
+var comicFormats = []string{"CBZ", "CBR", "CB7"}
+
+type Comic struct {
+	BookID int         `json:"book_id"`
+	Format string      `json:"format"`
+	Info   *ComicInfo  `json:"info,omitempty"`
+	Pages  []ComicPage `json:"pages"`
+}
+
+type ComicPage struct {
+	Index     int    `json:"index"`
+	Name      string `json:"name"`
+	MediaType string `json:"media_type"`
+}
+
+// ComicInfo holds the metadata found in the ComicInfo.xml file of a comic archive
+type ComicInfo struct {
+	XMLName     xml.Name `xml:"ComicInfo" json:"-"`
+	Title       string   `xml:"Title" json:"title,omitempty"`
+	Series      string   `xml:"Series" json:"series,omitempty"`
+	Number      string   `xml:"Number" json:"number,omitempty"`
+	Count       int      `xml:"Count" json:"count,omitempty"`
+	Volume      int      `xml:"Volume" json:"volume,omitempty"`
+	Summary     string   `xml:"Summary" json:"summary,omitempty"`
+	Year        int      `xml:"Year" json:"year,omitempty"`
+	Month       int      `xml:"Month" json:"month,omitempty"`
+	Writer      string   `xml:"Writer" json:"writer,omitempty"`
+	Penciller   string   `xml:"Penciller" json:"penciller,omitempty"`
+	Inker       string   `xml:"Inker" json:"inker,omitempty"`
+	Colorist    string   `xml:"Colorist" json:"colorist,omitempty"`
+	Letterer    string   `xml:"Letterer" json:"letterer,omitempty"`
+	CoverArtist string   `xml:"CoverArtist" json:"cover_artist,omitempty"`
+	Publisher   string   `xml:"Publisher" json:"publisher,omitempty"`
+	Genre       string   `xml:"Genre" json:"genre,omitempty"`
+	LanguageISO string   `xml:"LanguageISO" json:"language,omitempty"`
+	PageCount   int      `xml:"PageCount" json:"page_count,omitempty"`
+	Manga       string   `xml:"Manga" json:"manga,omitempty"`
+}
+
+// comicArchive gives access to the files of a CBZ, CBR or CB7 archive
+type comicArchive interface {
+	Names() []string
+	ReadFile(name string) ([]byte, error)
+	Close() error
+}
+
+// GetComic lists the pages of a comic book in reading order, along with its
+// ComicInfo.xml metadata when present
+func GetComic(ctx context.Context, db *DB, libraryPath string, bookID int) (*Comic, error) {
+	archive, format, err := openComic(ctx, db, libraryPath, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	comic := &Comic{
+		BookID: bookID,
+		Format: format,
+		Pages:  make([]ComicPage, 0),
+	}
+
+	for i, name := range comicPageNames(archive) {
+		comic.Pages = append(comic.Pages, ComicPage{
+			Index:     i,
+			Name:      name,
+			MediaType: imageMediaType(name, nil),
+		})
+	}
+
+	for _, name := range archive.Names() {
+		if !strings.EqualFold(path.Base(name), "ComicInfo.xml") {
+			continue
+		}
+		data, err := archive.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ComicInfo.xml: %w", err)
+		}
+		var info ComicInfo
+		if err := xml.Unmarshal(data, &info); err != nil {
+			return nil, fmt.Errorf("failed to parse ComicInfo.xml: %w", err)
+		}
+		comic.Info = &info
+		break
+	}
+
+	return comic, nil
+}
+
+// GetComicPage returns a single page of a comic book as an image, downscaled
+// so that neither side exceeds maxDimension when it is greater than zero
+func GetComicPage(ctx context.Context, db *DB, libraryPath string, bookID int, pageIndex int, maxDimension int) (*Image, error) {
+	archive, _, err := openComic(ctx, db, libraryPath, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	pages := comicPageNames(archive)
+	if pageIndex < 0 || pageIndex >= len(pages) {
+		return nil, fmt.Errorf("page index out of range")
+	}
+
+	data, err := archive.ReadFile(pages[pageIndex])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page: %w", err)
+	}
+
+	return newImage(data, maxDimension)
+}
+
+func openComic(ctx context.Context, db *DB, libraryPath string, bookID int) (comicArchive, string, error) {
+	for _, format := range comicFormats {
+		comicPath, err := getFormatPath(db, libraryPath, bookID, format)
+		if err != nil {
+			continue
+		}
+		var archive comicArchive
+		if format == "CBZ" {
+			archive, err = openZipComic(comicPath)
+		} else {
+			archive, err = openExternalComic(ctx, comicPath)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open %s: %w", format, err)
+		}
+		return archive, format, nil
+	}
+	return nil, "", fmt.Errorf("no comic book archive (CBZ, CBR or CB7) found for book %d", bookID)
+}
+
+// comicPageNames returns the image files of the archive in natural sort order,
+// skipping hidden files and macOS resource forks
+func comicPageNames(archive comicArchive) []string {
+	var pages []string
+	for _, name := range archive.Names() {
+		if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".") {
+			continue
+		}
+		if isImageFile(name) {
+			pages = append(pages, name)
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		return naturalLess(pages[i], pages[j])
+	})
+	return pages
+}
+
+// naturalLess compares strings case-insensitively, treating runs of digits as
+// numbers so that "page2" sorts before "page10"
+func naturalLess(a, b string) bool {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	i, j := 0, 0
+	for i < len(ra) && j < len(rb) {
+		if unicode.IsDigit(ra[i]) && unicode.IsDigit(rb[j]) {
+			si := i
+			for i < len(ra) && unicode.IsDigit(ra[i]) {
+				i++
+			}
+			sj := j
+			for j < len(rb) && unicode.IsDigit(rb[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ra[si:i]), "0")
+			nb := strings.TrimLeft(string(rb[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if ra[i] != rb[j] {
+			return ra[i] < rb[j]
+		}
+		i++
+		j++
+	}
+	if len(ra)-i != len(rb)-j {
+		return len(ra)-i < len(rb)-j
+	}
+	return a < b
+}
+
+type zipComic struct {
+	r *zip.ReadCloser
+}
+
+func openZipComic(comicPath string) (*zipComic, error) {
+	r, err := zip.OpenReader(comicPath)
+	if err != nil {
+		return nil, err
+	}
+	return &zipComic{r: r}, nil
+}
+
+func (z *zipComic) Names() []string {
+	names := make([]string, 0, len(z.r.File))
+	for _, f := range z.r.File {
+		if !f.FileInfo().IsDir() {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+func (z *zipComic) ReadFile(name string) ([]byte, error) {
+	f, err := z.r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (z *zipComic) Close() error {
+	return z.r.Close()
+}
+
+// externalComic reads RAR and 7z archives, which the standard library cannot
+// open, through bsdtar (libarchive) or 7z. The commands are killed when ctx
+// is done, so that a hung extractor does not block the tool call.
+type externalComic struct {
+	ctx   context.Context
+	tool  string
+	path  string
+	names []string
+}
+
+func openExternalComic(ctx context.Context, comicPath string) (*externalComic, error) {
+	for _, tool := range []string{"bsdtar", "7z"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			continue
+		}
+		c := &externalComic{ctx: ctx, tool: tool, path: comicPath}
+		if err := c.list(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("reading CBR and CB7 archives requires bsdtar or 7z in PATH")
+}
+
+func (c *externalComic) list() error {
+	var cmd *exec.Cmd
+	if c.tool == "bsdtar" {
+		cmd = exec.CommandContext(c.ctx, "bsdtar", "-tf", c.path)
+	} else {
+		cmd = exec.CommandContext(c.ctx, "7z", "l", "-ba", "-slt", c.path)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("%s failed to list archive: %w", c.tool, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if c.tool == "7z" {
+			name, ok := strings.CutPrefix(line, "Path = ")
+			if !ok {
+				continue
+			}
+			line = name
+		}
+		if line != "" && !strings.HasSuffix(line, "/") {
+			c.names = append(c.names, line)
+		}
+	}
+	return scanner.Err()
+}
+
+func (c *externalComic) Names() []string {
+	return c.names
+}
+
+// ReadFile extracts a member by its exact name. Both tools take member names
+// as wildcards, which bsdtar can't turn off, so bracketed names such as
+// p[01].jpg are escaped for it, and -- keeps names starting with - from being
+// taken as options.
+func (c *externalComic) ReadFile(name string) ([]byte, error) {
+	var cmd *exec.Cmd
+	if c.tool == "bsdtar" {
+		cmd = exec.CommandContext(c.ctx, "bsdtar", "-xOf", c.path, "--", escapeGlob(name))
+	} else {
+		cmd = exec.CommandContext(c.ctx, "7z", "e", "-so", "-spd", c.path, "--", name)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed to extract %s: %w", c.tool, name, err)
+	}
+	return out, nil
+}
+
+func (c *externalComic) Close() error {
+	return nil
+}
+
+// escapeGlob escapes the characters of a name that bsdtar matches as a
+// pattern
+func escapeGlob(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(`\*?[]`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,240 @@
+package calibre
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// MetadataMismatch is a field whose value embedded in a book file differs
+// from the one in metadata.db. Values are given like FieldValue ones.
+type MetadataMismatch struct {
+	Field   string `json:"field"`
+	Library any    `json:"library"`
+	File    any    `json:"file"`
+}
+
+// BookMismatches lists the fields of a book whose embedded value differs
+// from metadata.db. File is relative to the library, and Error is set instead
+// when the file could not be read.
+type BookMismatches struct {
+	BookID     int                `json:"book_id"`
+	Title      string             `json:"title"`
+	File       string             `json:"file"`
+	Mismatches []MetadataMismatch `json:"mismatches"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// MetadataReport lists the books whose embedded metadata disagrees with
+// metadata.db, with the number of mismatches by field
+type MetadataReport struct {
+	Books   int              `json:"books"`
+	Results []BookMismatches `json:"results"`
+	Counts  map[string]int   `json:"counts"`
+}
+
+// CompareMetadata compares the metadata embedded in the OPF of EPUB files
+// with metadata.db, for one book or for every book with an EPUB when bookID
+// is zero. Only the fields set in a file are compared, so that files without
+// tags or series are not reported: title, authors, tags, series and index,
+// rating, publisher, publication date, languages and identifiers. Titles,
+// names and tags are compared ignoring case, and lists ignoring order.
+func CompareMetadata(ctx context.Context, db *DB, libraryPath string, bookID int) (*MetadataReport, error) {
+	query := `
+		SELECT b.id, b.title, b.path, d.name
+		FROM books b
+		JOIN data d ON b.id = d.book
+		WHERE d.format = 'EPUB'`
+	var args []any
+	if bookID != 0 {
+		query += " AND b.id = ?"
+		args = append(args, bookID)
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY b.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	var books []BookMismatches
+	for rows.Next() {
+		var book BookMismatches
+		var bookPath, name string
+		if err := rows.Scan(&book.BookID, &book.Title, &bookPath, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		book.File = path.Join(bookPath, name+".epub")
+		books = append(books, book)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if bookID != 0 && len(books) == 0 {
+		return nil, fmt.Errorf("book %d has no EPUB", bookID)
+	}
+
+	report := &MetadataReport{Books: len(books), Results: []BookMismatches{}, Counts: make(map[string]int)}
+	e := &editor{tx: db, columns: make(map[string]*customColumn)}
+	for _, book := range books {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		md, err := readEPUBMetadata(filepath.Join(libraryPath, filepath.FromSlash(book.File)))
+		if err != nil {
+			book.Error = err.Error()
+			report.Results = append(report.Results, book)
+			continue
+		}
+		book.Mismatches, err = e.compareMetadata(ctx, book.BookID, md)
+		if err != nil {
+			return nil, err
+		}
+		if len(book.Mismatches) > 0 {
+			report.Results = append(report.Results, book)
+		}
+		for _, mismatch := range book.Mismatches {
+			report.Counts[mismatch.Field]++
+		}
+	}
+	return report, nil
+}
+
+// readEPUBMetadata reads the OPF metadata of an EPUB
+func readEPUBMetadata(epubPath string) (*Metadata, error) {
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	defer r.Close()
+
+	pkg, _, err := readPackage(r)
+	if err != nil {
+		return nil, err
+	}
+	return &pkg.Metadata, nil
+}
+
+// compareMetadata returns the fields of a book set in md to other values than
+// the ones of metadata.db
+func (e *editor) compareMetadata(ctx context.Context, bookID int, md *Metadata) ([]MetadataMismatch, error) {
+	mismatches := []MetadataMismatch{}
+	compare := func(field string, file any, same func(library any) bool) error {
+		library, err := e.get(ctx, bookID, field)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		if !same(library) {
+			mismatches = append(mismatches, MetadataMismatch{Field: field, Library: library, File: file})
+		}
+		return nil
+	}
+	sameText := func(file string) func(any) bool {
+		return func(library any) bool {
+			return strings.EqualFold(strings.Join(strings.Fields(library.(string)), " "), strings.Join(strings.Fields(file), " "))
+		}
+	}
+	sameNumber := func(file float64) func(any) bool {
+		return func(library any) bool { return math.Abs(library.(float64)-file) < 0.01 }
+	}
+
+	if title := md.title(); title != "" {
+		if err := compare(FieldTitle, title, sameText(title)); err != nil {
+			return nil, err
+		}
+	}
+	if creators := md.authors(); len(creators) > 0 {
+		authors := make([]string, len(creators))
+		for i, creator := range creators {
+			authors[i] = creator.Name
+		}
+		// Calibre keeps commas out of author names in the database only
+		err := compare(FieldAuthors, authors, func(library any) bool {
+			names := slices.Clone(library.([]string))
+			for i, name := range names {
+				names[i] = strings.ReplaceAll(name, "|", ",")
+			}
+			return sameNames(names, authors)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tags := md.subjects(); len(tags) > 0 {
+		if err := compare(FieldTags, tags, func(library any) bool { return sameNames(library.([]string), tags) }); err != nil {
+			return nil, err
+		}
+	}
+	if series, index := md.series(); series != "" {
+		if err := compare(FieldSeries, series, sameText(series)); err != nil {
+			return nil, err
+		}
+		if err := compare(FieldSeriesIndex, index, sameNumber(index)); err != nil {
+			return nil, err
+		}
+	}
+	if rating := md.rating(); rating > 0 {
+		if err := compare(FieldRating, rating, sameNumber(rating)); err != nil {
+			return nil, err
+		}
+	}
+	if publisher := md.publisher(); publisher != "" {
+		if err := compare(FieldPublisher, publisher, sameText(publisher)); err != nil {
+			return nil, err
+		}
+	}
+	if date := md.pubDate(); date != "" {
+		// Files may only give the year or the month
+		err := compare(FieldPubDate, date, func(library any) bool {
+			return strings.HasPrefix(library.(string), date)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if languages := md.languages(); len(languages) > 0 {
+		library, err := e.linkedNames(ctx, languageItems, bookID)
+		if err != nil {
+			return nil, fmt.Errorf("languages: %w", err)
+		}
+		if !sameNames(library, languages) {
+			mismatches = append(mismatches, MetadataMismatch{Field: "languages", Library: library, File: languages})
+		}
+	}
+	identifiers := md.identifiers()
+	for _, typ := range sortedMapKeys(identifiers) {
+		val := identifiers[typ]
+		err := compare(identifierFieldPrefix+typ, val, func(library any) bool {
+			if typ == "isbn" {
+				return normalizeISBN(library.(string)) == normalizeISBN(val)
+			}
+			return strings.EqualFold(library.(string), val)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mismatches, nil
+}
+
+// sameNames reports whether two lists hold the same names, ignoring case,
+// order and repeats
+func sameNames(a []string, b []string) bool {
+	set := func(names []string) []string {
+		keys := make([]string, 0, len(names))
+		for _, name := range names {
+			key := strings.ToLower(strings.Join(strings.Fields(name), " "))
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	return slices.Equal(set(a), set(b))
+}
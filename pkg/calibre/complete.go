@@ -0,0 +1,137 @@
+package calibre
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// completionQueries list the values of each completable field along with the
+// number of books using them
+var completionQueries = map[string]string{
+	"authors": `
+		SELECT a.name, COUNT(l.book)
+		FROM authors a
+		LEFT JOIN books_authors_link l ON a.id = l.author
+		GROUP BY a.id
+	`,
+	"tags": `
+		SELECT t.name, COUNT(l.book)
+		FROM tags t
+		LEFT JOIN books_tags_link l ON t.id = l.tag
+		GROUP BY t.id
+	`,
+	"series": `
+		SELECT s.name, COUNT(l.book)
+		FROM series s
+		LEFT JOIN books_series_link l ON s.id = l.series
+		GROUP BY s.id
+	`,
+	"publishers": `
+		SELECT p.name, COUNT(l.book)
+		FROM publishers p
+		LEFT JOIN books_publishers_link l ON p.id = l.publisher
+		GROUP BY p.id
+	`,
+	"languages": `
+		SELECT g.lang_code, COUNT(l.book)
+		FROM languages g
+		LEFT JOIN books_languages_link l ON g.id = l.lang_code
+		GROUP BY g.id
+	`,
+	"formats": `
+		SELECT format, COUNT(*)
+		FROM data
+		GROUP BY format
+	`,
+	"titles": `
+		SELECT title, COUNT(*)
+		FROM books
+		GROUP BY title
+	`,
+}
+
+type completion struct {
+	value string
+	count int
+}
+
+// Complete returns the values of a field (authors, tags, series, publishers,
+// languages, formats or titles) that start with prefix, ignoring case and
+// accents. Values used by the most books come first. It also returns the total
+// number of matches, which may exceed limit.
+func Complete(ctx context.Context, db *DB, field string, prefix string, limit int) ([]string, int, error) {
+	query, ok := completionQueries[field]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown field %q", field)
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	prefix = fold(prefix)
+	var matches []completion
+	for rows.Next() {
+		var c completion
+		if err := rows.Scan(&c.value, &c.count); err != nil {
+			return nil, 0, err
+		}
+		if strings.HasPrefix(fold(c.value), prefix) {
+			matches = append(matches, c)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].count != matches[j].count {
+			return matches[i].count > matches[j].count
+		}
+		return fold(matches[i].value) < fold(matches[j].value)
+	})
+
+	return completionValues(matches, limit), len(matches), nil
+}
+
+// CompleteBookIDs returns the IDs of the books whose ID or title starts with
+// prefix, ignoring case and accents in titles. It also returns the total
+// number of matches, which may exceed limit.
+func CompleteBookIDs(ctx context.Context, db *DB, prefix string, limit int) ([]string, int, error) {
+	titles, err := ListBookTitles(ctx, db)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	prefix = strings.TrimSpace(prefix)
+	folded := fold(prefix)
+	var ids []int
+	for id, title := range titles {
+		if strings.HasPrefix(strconv.Itoa(id), prefix) || strings.HasPrefix(fold(title), folded) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	matches := make([]completion, len(ids))
+	for i, id := range ids {
+		matches[i] = completion{value: strconv.Itoa(id)}
+	}
+	return completionValues(matches, limit), len(matches), nil
+}
+
+func completionValues(matches []completion, limit int) []string {
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	values := make([]string, len(matches))
+	for i, c := range matches {
+		values[i] = c.value
+	}
+	return values
+}
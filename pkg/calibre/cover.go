@@ -0,0 +1,115 @@
+package calibre
+
+import (
+	"archive/zip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// coverFileName is the name Calibre gives the cover stored in each book
+	// folder
+	coverFileName = "cover.jpg"
+	// coverMaxDimension bounds covers when no maximum dimension is given
+	coverMaxDimension = 1024
+)
+
+// GetBookCover returns the cover of a book as a JPEG, downscaled so that
+// neither side exceeds maxDimension, or coverMaxDimension when it is zero or
+// less. The cover.jpg file of the book folder is preferred, and the cover
+// image declared by the EPUB is used as a fallback.
+func GetBookCover(db *DB, libraryPath string, bookID int, maxDimension int) (*Image, error) {
+	if maxDimension <= 0 {
+		maxDimension = coverMaxDimension
+	}
+	var bookPath string
+	var hasCover bool
+	err := db.QueryRow(`
+		SELECT path, has_cover
+		FROM books
+		WHERE id = ?
+	`, bookID).Scan(&bookPath, &hasCover)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("book not found")
+		}
+		return nil, err
+	}
+
+	if hasCover {
+		data, err := os.ReadFile(filepath.Join(libraryPath, bookPath, coverFileName))
+		if err == nil {
+			return newCoverImage(data, maxDimension)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read cover: %w", err)
+		}
+	}
+
+	data, err := readEPUBCover(db, libraryPath, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("no cover found for book %d", bookID)
+	}
+	return newCoverImage(data, maxDimension)
+}
+
+// newCoverImage downscales a cover like newImage, and re-encodes covers that
+// are small enough but not JPEG, such as the PNG, GIF or WebP of some EPUBs
+func newCoverImage(data []byte, maxDimension int) (*Image, error) {
+	img, err := newImage(data, maxDimension)
+	if err != nil {
+		return nil, err
+	}
+	if img.MIMEType != "image/jpeg" {
+		if img.Data, err = toJPEG(img.Data); err != nil {
+			return nil, err
+		}
+		img.MIMEType = "image/jpeg"
+	}
+	return img, nil
+}
+
+// readEPUBCover reads the cover image declared in the OPF of the book's EPUB
+func readEPUBCover(db *DB, libraryPath string, bookID int) ([]byte, error) {
+	epubPath, err := getEPUBPath(db, libraryPath, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	defer r.Close()
+
+	pkg, opfPath, err := readPackage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	item, ok := pkg.coverItem()
+	if !ok {
+		return nil, fmt.Errorf("EPUB declares no cover image")
+	}
+
+	f, err := r.Open(resolveHref(opfPath, item.Href))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cover image: %w", err)
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// coverPath returns the location of a book's cover relative to the library,
+// or an empty string if the book has no cover
+func coverPath(bookPath string, hasCover bool) string {
+	if !hasCover {
+		return ""
+	}
+	return filepath.Join(bookPath, coverFileName)
+}
@@ -0,0 +1,355 @@
+package calibre
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// querier is implemented by both DB and sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// hasTable reports whether the database has a table, for the tables that
+// older versions of Calibre don't create
+func hasTable(ctx context.Context, q querier, name string) (bool, error) {
+	var n int
+	err := q.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM sqlite_master
+		WHERE type = 'table' AND name = ?
+	`, name).Scan(&n)
+	return n > 0, err
+}
+
+// customColumn describes a user-defined column. Calibre stores the values of
+// custom column N in custom_column_N, linked to books through
+// books_custom_column_N_link when the column is normalized.
+type customColumn struct {
+	id         int
+	label      string
+	datatype   string
+	normalized bool
+	multiple   bool
+}
+
+// readValues are the text and enumeration values that mean a book was read
+var readValues = map[string]bool{
+	"yes":      true,
+	"true":     true,
+	"read":     true,
+	"finished": true,
+	"done":     true,
+}
+
+// getCustomColumn returns the custom column with the given lookup name, with
+// or without its leading #, or nil if there is no such column
+func getCustomColumn(ctx context.Context, db querier, label string) (*customColumn, error) {
+	label = strings.TrimPrefix(strings.TrimSpace(label), "#")
+	if label == "" {
+		return nil, nil
+	}
+
+	c := customColumn{label: label}
+	err := db.QueryRowContext(ctx, `
+		SELECT id, datatype, normalized, is_multiple
+		FROM custom_columns
+		WHERE label = ? COLLATE NOCASE AND mark_for_delete = 0
+	`, label).Scan(&c.id, &c.datatype, &c.normalized, &c.multiple)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// readState reports whether a book was read according to the column. Yes/no
+// columns must be set to yes, dates (such as a date read) and numbers (such as
+// a read count) must be set and non-zero, and text must say yes, read,
+// finished or done.
+func (c *customColumn) readState(ctx context.Context, db *DB, bookID int) (bool, error) {
+	rows, err := db.QueryContext(ctx, c.valuesQuery(), bookID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value any
+		if err := rows.Scan(&value); err != nil {
+			return false, err
+		}
+		switch c.datatype {
+		case "bool", "int", "float", "rating":
+			var n float64
+			switch v := value.(type) {
+			case int64:
+				n = float64(v)
+			case float64:
+				n = v
+			}
+			if n != 0 {
+				return true, nil
+			}
+		case "datetime":
+			if value != nil {
+				return true, nil
+			}
+		case "text", "enumeration":
+			var s string
+			switch v := value.(type) {
+			case string:
+				s = v
+			case []byte:
+				s = string(v)
+			}
+			if readValues[strings.ToLower(strings.TrimSpace(s))] {
+				return true, nil
+			}
+		default:
+			return false, fmt.Errorf("custom column #%s has type %s, which can't hold a read state", c.label, c.datatype)
+		}
+	}
+	return false, rows.Err()
+}
+
+// valuesQuery returns the query selecting the values of a book in the column
+func (c *customColumn) valuesQuery() string {
+	if c.normalized {
+		return fmt.Sprintf(`
+			SELECT v.value
+			FROM custom_column_%d v
+			JOIN books_custom_column_%d_link l ON v.id = l.value
+			WHERE l.book = ?
+			ORDER BY l.id
+		`, c.id, c.id)
+	}
+	return fmt.Sprintf(`
+		SELECT value
+		FROM custom_column_%d
+		WHERE book = ?
+	`, c.id)
+}
+
+// get returns the value of a book in the column, in the form taken by set:
+// a list of strings for columns with multiple values, a boolean, a number
+// (ratings in stars), a YYYY-MM-DD date or a string, or nil when not set
+func (c *customColumn) get(ctx context.Context, db querier, bookID int) (any, error) {
+	rows, err := db.QueryContext(ctx, c.valuesQuery(), bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []any
+	for rows.Next() {
+		var value any
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		switch c.datatype {
+		case "bool":
+			value = toNumber(value) != 0
+		case "int", "float":
+			value = toNumber(value)
+		case "rating":
+			value = toNumber(value) / 2
+		case "datetime":
+			if s, ok := value.(string); ok && len(s) >= 10 {
+				value = s[:10]
+			}
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if c.multiple {
+		names := make([]string, 0, len(values))
+		for _, value := range values {
+			names = append(names, fmt.Sprint(value))
+		}
+		sort.Slice(names, func(i, j int) bool { return fold(names[i]) < fold(names[j]) })
+		return names, nil
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return values[0], nil
+}
+
+func toNumber(value any) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+	}
+	return 0
+}
+
+// set replaces the value of a book in the column. Values no longer used by
+// any book are deleted from normalized columns.
+func (c *customColumn) set(ctx context.Context, db querier, bookID int, value any) error {
+	values, err := c.storedValues(ctx, db, value)
+	if err != nil {
+		return fmt.Errorf("custom column #%s: %w", c.label, err)
+	}
+
+	if !c.normalized {
+		_, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM custom_column_%d WHERE book = ?", c.id), bookID)
+		if err != nil || len(values) == 0 {
+			return err
+		}
+		_, err = db.ExecContext(ctx, fmt.Sprintf("INSERT INTO custom_column_%d (book, value) VALUES (?, ?)", c.id), bookID, values[0])
+		return err
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT value FROM books_custom_column_%d_link WHERE book = ?", c.id), bookID)
+	if err != nil {
+		return err
+	}
+	previous, err := scanIDs(rows)
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM books_custom_column_%d_link WHERE book = ?", c.id), bookID); err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		var id int64
+		err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT id FROM custom_column_%d WHERE value = ?", c.id), value).Scan(&id)
+		if err == sql.ErrNoRows {
+			var res sql.Result
+			res, err = db.ExecContext(ctx, fmt.Sprintf("INSERT INTO custom_column_%d (value) VALUES (?)", c.id), value)
+			if err == nil {
+				id, err = res.LastInsertId()
+			}
+		}
+		if err != nil {
+			return err
+		}
+		_, err = db.ExecContext(ctx, fmt.Sprintf("INSERT OR IGNORE INTO books_custom_column_%d_link (book, value) VALUES (?, ?)", c.id), bookID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range previous {
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`
+			DELETE FROM custom_column_%d
+			WHERE id = ? AND NOT EXISTS (SELECT 1 FROM books_custom_column_%d_link WHERE value = ?)
+		`, c.id, c.id), id, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedValues converts a value to the values stored in the column, none
+// when the value clears the column
+func (c *customColumn) storedValues(ctx context.Context, db querier, value any) ([]any, error) {
+	if c.multiple {
+		names, err := toStrings(value)
+		if err != nil {
+			return nil, err
+		}
+		var values []any
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				values = append(values, name)
+			}
+		}
+		return values, nil
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	switch c.datatype {
+	case "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected true or false, got %v", value)
+		}
+		return []any{b}, nil
+	case "int", "float", "rating":
+		n, err := toFloat(value)
+		if err != nil {
+			return nil, err
+		}
+		switch c.datatype {
+		case "int":
+			if *n != math.Trunc(*n) {
+				return nil, fmt.Errorf("expected an integer, got %v", *n)
+			}
+			return []any{int64(*n)}, nil
+		case "rating":
+			if *n == 0 {
+				return nil, nil
+			}
+			if *n < 0 || *n > 5 {
+				return nil, fmt.Errorf("rating must be between 0 and 5 stars")
+			}
+			return []any{int64(math.Round(*n * 2))}, nil
+		}
+		return []any{*n}, nil
+	case "datetime":
+		date, err := toDate(value)
+		if err != nil || date == undefinedDate {
+			return nil, err
+		}
+		return []any{date}, nil
+	case "text", "comments", "enumeration":
+		s, err := toString(value)
+		if err != nil || s == "" {
+			return nil, err
+		}
+		if c.datatype == "enumeration" {
+			allowed, err := c.enumValues(ctx, db)
+			if err != nil {
+				return nil, err
+			}
+			if !slices.Contains(allowed, s) {
+				return nil, fmt.Errorf("%q is not one of %s", s, strings.Join(allowed, ", "))
+			}
+		}
+		return []any{s}, nil
+	}
+	return nil, fmt.Errorf("columns of type %s can't be edited", c.datatype)
+}
+
+// enumValues returns the values allowed in an enumeration column
+func (c *customColumn) enumValues(ctx context.Context, db querier) ([]string, error) {
+	var display string
+	if err := db.QueryRowContext(ctx, "SELECT display FROM custom_columns WHERE id = ?", c.id).Scan(&display); err != nil {
+		return nil, err
+	}
+	var settings struct {
+		EnumValues []string `json:"enum_values"`
+	}
+	if err := json.Unmarshal([]byte(display), &settings); err != nil {
+		return nil, err
+	}
+	return settings.EnumValues, nil
+}
@@ -1,21 +1,112 @@
 package calibre
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"fmt"
+	"os"
 	"path/filepath"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// driverName is the SQLite driver with the SQL functions that Calibre's
+// triggers call when books, series and other items are written
+const driverName = "sqlite3_calibre"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("title_sort", titleSort, true); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("uuid4", newUUID, false)
+		},
+	})
+}
+
 type DB struct {
 	*sql.DB
+	journal *Journal
+	// notes is the read-only notes database of Calibre 7, or nil
+	notes *sql.DB
 }
 
 func OpenLibrary(path string) (*DB, error) {
-	dbPath := filepath.Join(path, "metadata.db")
-	db, err := sql.Open("sqlite3", dbPath)
+	// Write transactions take the lock immediately, and wait while Calibre
+	// holds it, rather than failing when they first write
+	dbPath := filepath.Join(path, "metadata.db") + "?_txlock=immediate&_busy_timeout=5000"
+	db, err := sql.Open(driverName, dbPath)
+	if err != nil {
+		return nil, err
+	}
+	notes, err := openNotes(path)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	return &DB{db}, nil
+	return &DB{DB: db, notes: notes}, nil
+}
+
+// Close closes metadata.db and the notes database
+func (db *DB) Close() error {
+	if db.notes != nil {
+		db.notes.Close()
+	}
+	return db.DB.Close()
+}
+
+// SetJournal makes every edit committed through the DB be recorded in the
+// journal, and metadata.db be backed up before the first one
+func (db *DB) SetJournal(journal *Journal) {
+	db.journal = journal
+}
+
+// Backup copies the database to path with SQLite's online backup, which
+// gives a consistent copy while other connections keep using the database
+func (db *DB) Backup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	dest, err := sql.Open(driverName, path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			backup, err := destDriverConn.(*sqlite3.SQLiteConn).Backup("main", srcDriverConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			// Copy everything in one step, holding a read lock meanwhile
+			if _, err := backup.Step(-1); err != nil {
+				backup.Close()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// newUUID returns a random version 4 UUID, as Calibre gives new books
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
 }
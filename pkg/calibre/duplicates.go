@@ -0,0 +1,306 @@
+package calibre
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Reasons for which books are grouped as duplicates
+const (
+	DuplicateTitleAuthor = "title_author"
+	DuplicateIdentifier  = "identifier"
+	DuplicateFuzzyTitle  = "fuzzy_title"
+)
+
+type FormatFile struct {
+	Format string `json:"format"`
+	Size   int64  `json:"size"`
+}
+
+// DuplicateBook is a book of a duplicate group, with what is needed to decide
+// which copy to keep
+type DuplicateBook struct {
+	ID           int               `json:"id"`
+	Title        string            `json:"title"`
+	Authors      []string          `json:"authors"`
+	Formats      []FormatFile      `json:"formats"`
+	Identifiers  map[string]string `json:"identifiers"`
+	PubDate      string            `json:"pubdate"`
+	Timestamp    string            `json:"timestamp"`
+	LastModified string            `json:"last_modified"`
+}
+
+// DuplicateGroup is a set of books that are likely copies of each other, with
+// the reasons they were grouped and the shared keys, such as isbn:9780441013593
+type DuplicateGroup struct {
+	Reasons []string        `json:"reasons"`
+	Keys    []string        `json:"keys"`
+	Books   []DuplicateBook `json:"books"`
+}
+
+// FindDuplicates groups the books that share their normalized title and
+// authors, or an identifier such as an ISBN. When similarity is greater than
+// zero, books by a same author whose titles are at least that similar (from 0
+// to 1) are grouped too. Groups linked by a common book are merged.
+func FindDuplicates(ctx context.Context, db *DB, similarity float64) ([]DuplicateGroup, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, title, pubdate, timestamp, last_modified
+		FROM books
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	var books []DuplicateBook
+	for rows.Next() {
+		var book DuplicateBook
+		if err := rows.Scan(&book.ID, &book.Title, &book.PubDate, &book.Timestamp, &book.LastModified); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range books {
+		if books[i].Authors, err = getAuthorsForBook(db, books[i].ID); err != nil {
+			return nil, err
+		}
+		if books[i].Identifiers, err = getIdentifiersForBook(db, books[i].ID); err != nil {
+			return nil, err
+		}
+		if books[i].Formats, err = getFormatFilesForBook(ctx, db, books[i].ID); err != nil {
+			return nil, err
+		}
+	}
+
+	groups := newDuplicateSets(len(books))
+
+	// Same normalized title and authors
+	byTitle := make(map[string][]int)
+	for i, book := range books {
+		authors := make([]string, len(book.Authors))
+		for j, author := range book.Authors {
+			authors[j] = normalizeTitle(author)
+		}
+		sort.Strings(authors)
+		key := normalizeTitle(book.Title) + "/" + strings.Join(authors, "&")
+		byTitle[key] = append(byTitle[key], i)
+	}
+	for _, indices := range byTitle {
+		groups.join(indices, DuplicateTitleAuthor, "")
+	}
+
+	// Shared identifiers, with ISBNs compared in their ISBN-13 form
+	byIdentifier := make(map[string][]int)
+	for i, book := range books {
+		for typ, val := range book.Identifiers {
+			key := strings.ToLower(typ) + ":" + strings.ToLower(strings.TrimSpace(val))
+			if strings.HasPrefix(strings.ToLower(typ), "isbn") {
+				isbn := normalizeISBN(val)
+				if isbn == "" {
+					continue
+				}
+				key = "isbn:" + isbn
+			}
+			byIdentifier[key] = append(byIdentifier[key], i)
+		}
+	}
+	for key, indices := range byIdentifier {
+		groups.join(indices, DuplicateIdentifier, key)
+	}
+
+	// Similar titles by a same author
+	if similarity > 0 {
+		byAuthor := make(map[string][]int)
+		for i, book := range books {
+			for _, author := range book.Authors {
+				key := normalizeTitle(author)
+				byAuthor[key] = append(byAuthor[key], i)
+			}
+		}
+		for _, indices := range byAuthor {
+			for a := 0; a < len(indices); a++ {
+				for b := a + 1; b < len(indices); b++ {
+					first, second := normalizeTitle(books[indices[a]].Title), normalizeTitle(books[indices[b]].Title)
+					if first != second && titleSimilarity(first, second) >= similarity {
+						groups.join([]int{indices[a], indices[b]}, DuplicateFuzzyTitle, "")
+					}
+				}
+			}
+		}
+	}
+
+	return groups.groups(books), nil
+}
+
+func getFormatFilesForBook(ctx context.Context, db *DB, bookID int) ([]FormatFile, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT format, COALESCE(uncompressed_size, 0)
+		FROM data
+		WHERE book = ?
+		ORDER BY format
+	`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	formats := []FormatFile{}
+	for rows.Next() {
+		var f FormatFile
+		if err := rows.Scan(&f.Format, &f.Size); err != nil {
+			return nil, err
+		}
+		formats = append(formats, f)
+	}
+	return formats, rows.Err()
+}
+
+// normalizeTitle folds case and accents and drops punctuation, so that
+// "The Lord of the Rings: 1" and "the lord of the rings 1" are equal
+func normalizeTitle(s string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(fold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(word)
+	}
+	return b.String()
+}
+
+// titleSimilarity returns 1 minus the edit distance between a and b divided
+// by the length of the longer one
+func titleSimilarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+
+	// Levenshtein distance, keeping a single row of the matrix
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			next := min(row[j]+1, row[j-1]+1, diagonal+cost)
+			diagonal = row[j]
+			row[j] = next
+		}
+	}
+	return 1 - float64(row[len(rb)])/float64(longest)
+}
+
+// duplicateSets is a union-find over book indices, recording why sets were
+// joined
+type duplicateSets struct {
+	parent  []int
+	reasons map[int]map[string]bool
+	keys    map[int]map[string]bool
+}
+
+func newDuplicateSets(n int) *duplicateSets {
+	s := &duplicateSets{
+		parent:  make([]int, n),
+		reasons: make(map[int]map[string]bool),
+		keys:    make(map[int]map[string]bool),
+	}
+	for i := range s.parent {
+		s.parent[i] = i
+	}
+	return s
+}
+
+func (s *duplicateSets) find(i int) int {
+	for s.parent[i] != i {
+		s.parent[i] = s.parent[s.parent[i]]
+		i = s.parent[i]
+	}
+	return i
+}
+
+// join merges the sets of the given books, if there are at least two
+func (s *duplicateSets) join(indices []int, reason string, key string) {
+	if len(indices) < 2 {
+		return
+	}
+	root := s.find(indices[0])
+	for _, i := range indices[1:] {
+		other := s.find(i)
+		if other == root {
+			continue
+		}
+		s.parent[other] = root
+		for r := range s.reasons[other] {
+			s.mark(s.reasons, root, r)
+		}
+		for k := range s.keys[other] {
+			s.mark(s.keys, root, k)
+		}
+		delete(s.reasons, other)
+		delete(s.keys, other)
+	}
+	s.mark(s.reasons, root, reason)
+	if key != "" {
+		s.mark(s.keys, root, key)
+	}
+}
+
+func (s *duplicateSets) mark(m map[int]map[string]bool, root int, value string) {
+	if m[root] == nil {
+		m[root] = make(map[string]bool)
+	}
+	m[root][value] = true
+}
+
+// groups returns the sets of more than one book, ordered by their lowest
+// book ID
+func (s *duplicateSets) groups(books []DuplicateBook) []DuplicateGroup {
+	members := make(map[int][]DuplicateBook)
+	var roots []int
+	for i, book := range books {
+		root := s.find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], book)
+	}
+
+	groups := []DuplicateGroup{}
+	for _, root := range roots {
+		if len(members[root]) < 2 {
+			continue
+		}
+		groups = append(groups, DuplicateGroup{
+			Reasons: sortedKeys(s.reasons[root]),
+			Keys:    sortedKeys(s.keys[root]),
+			Books:   members[root],
+		})
+	}
+	return groups
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,700 @@
+package calibre
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fields that can be edited. Identifiers are edited as identifier:<type>, such
+// as identifier:isbn, and custom columns as #<label>, such as #read.
+const (
+	FieldTitle       = "title"
+	FieldAuthors     = "authors"
+	FieldTags        = "tags"
+	FieldSeries      = "series"
+	FieldSeriesIndex = "series_index"
+	FieldRating      = "rating"
+	FieldPublisher   = "publisher"
+	FieldPubDate     = "pubdate"
+	FieldComments    = "comments"
+
+	identifierFieldPrefix = "identifier:"
+)
+
+// undefinedDate is the date Calibre stores when a date is not set
+const undefinedDate = "0101-01-01 00:00:00+00:00"
+
+// FieldValue is a value to give a field of a book. Values are strings, lists
+// of strings for authors, tags and multiple-value custom columns, numbers for
+// the series index, ratings (in stars, from 0.5 to 5) and numeric custom
+// columns, and booleans for yes/no custom columns. Dates are written as
+// YYYY-MM-DD. An empty value or nil clears the field.
+type FieldValue struct {
+	Field string `json:"field"`
+	Value any    `json:"value"`
+}
+
+// FieldChange records the value of a field before and after an edit
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// BookChange lists the fields of a book changed by an edit
+type BookChange struct {
+	BookID  int           `json:"book_id"`
+	Title   string        `json:"title"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// BookEdit is a set of values to give the fields of a book
+type BookEdit struct {
+	BookID int          `json:"book_id"`
+	Fields []FieldValue `json:"fields"`
+}
+
+// BookUpdate lists the new metadata of a book. Nil fields are left unchanged,
+// while empty ones are cleared. Identifiers and custom columns not listed are
+// left unchanged.
+type BookUpdate struct {
+	Title         *string           `json:"title,omitempty"`
+	Authors       []string          `json:"authors,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Series        *string           `json:"series,omitempty"`
+	SeriesIndex   *float64          `json:"series_index,omitempty"`
+	Rating        *float64          `json:"rating,omitempty"`
+	Publisher     *string           `json:"publisher,omitempty"`
+	PubDate       *string           `json:"pubdate,omitempty"`
+	Comments      *string           `json:"comments,omitempty"`
+	Identifiers   map[string]string `json:"identifiers,omitempty"`
+	CustomColumns map[string]any    `json:"custom_columns,omitempty"`
+}
+
+// Fields returns the values set by the update
+func (u BookUpdate) Fields() []FieldValue {
+	var fields []FieldValue
+	if u.Title != nil {
+		fields = append(fields, FieldValue{FieldTitle, *u.Title})
+	}
+	if u.Authors != nil {
+		fields = append(fields, FieldValue{FieldAuthors, u.Authors})
+	}
+	if u.Tags != nil {
+		fields = append(fields, FieldValue{FieldTags, u.Tags})
+	}
+	if u.Series != nil {
+		fields = append(fields, FieldValue{FieldSeries, *u.Series})
+	}
+	if u.SeriesIndex != nil {
+		fields = append(fields, FieldValue{FieldSeriesIndex, *u.SeriesIndex})
+	}
+	if u.Rating != nil {
+		fields = append(fields, FieldValue{FieldRating, *u.Rating})
+	}
+	if u.Publisher != nil {
+		fields = append(fields, FieldValue{FieldPublisher, *u.Publisher})
+	}
+	if u.PubDate != nil {
+		fields = append(fields, FieldValue{FieldPubDate, *u.PubDate})
+	}
+	if u.Comments != nil {
+		fields = append(fields, FieldValue{FieldComments, *u.Comments})
+	}
+	for _, typ := range sortedMapKeys(u.Identifiers) {
+		fields = append(fields, FieldValue{identifierFieldPrefix + typ, u.Identifiers[typ]})
+	}
+	for _, label := range sortedMapKeys(u.CustomColumns) {
+		fields = append(fields, FieldValue{"#" + strings.TrimPrefix(label, "#"), u.CustomColumns[label]})
+	}
+	return fields
+}
+
+func sortedMapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EditBooks applies edits to books in a single transaction, following
+// Calibre's schema: items are shared through link tables and removed once
+// unused, sort keys are generated, and last_modified is updated along with
+// the metadata_dirtied table so that Calibre rewrites its OPF backups. Book
+// folders are not renamed. It returns the fields that actually changed, which
+// are recorded in the journal of the DB if it has one. With dryRun, the
+// transaction is rolled back, so the changes are only reported.
+func EditBooks(ctx context.Context, db *DB, edits []BookEdit, dryRun bool) (*ChangeSet, error) {
+	return editBooks(ctx, db, edits, editOptions{commit: !dryRun})
+}
+
+type editOptions struct {
+	commit   bool                     // commit the transaction rather than roll it back
+	verify   func([]BookChange) error // accepts the changes before they are committed
+	revertOf int                      // ID of the change set the edits revert
+}
+
+func editBooks(ctx context.Context, db *DB, edits []BookEdit, opts editOptions) (*ChangeSet, error) {
+	return runEdit(ctx, db, opts, func(e *editor, set *ChangeSet) error {
+		for _, edit := range edits {
+			change, err := e.editBook(ctx, edit)
+			if err != nil {
+				return fmt.Errorf("book %d: %w", edit.BookID, err)
+			}
+			if len(change.Changes) > 0 {
+				set.Changes = append(set.Changes, *change)
+			}
+		}
+		return nil
+	})
+}
+
+// runEdit runs fn in a transaction, which is committed if opts.commit is set
+// and opts.verify, when given, accepts the changes. metadata.db is backed up
+// before the first committed edit and the changes are journaled, when the DB
+// has a journal. Changes that were committed but not journaled return their
+// set along with a *JournalError.
+func runEdit(ctx context.Context, db *DB, opts editOptions, fn func(e *editor, set *ChangeSet) error) (*ChangeSet, error) {
+	if opts.commit && db.journal != nil {
+		if err := db.journal.prepare(ctx, db); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	e := &editor{tx: tx, columns: make(map[string]*customColumn)}
+	set := &ChangeSet{RevertOf: opts.revertOf, Changes: []BookChange{}}
+	if err := fn(e, set); err != nil {
+		return nil, err
+	}
+
+	if opts.verify != nil {
+		if err := opts.verify(set.Changes); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.commit || len(set.Changes) == 0 {
+		return set, nil
+	}
+
+	if db.journal != nil {
+		if err := db.journal.commit(tx, set); err != nil {
+			var journalErr *JournalError
+			if errors.As(err, &journalErr) {
+				return set, err
+			}
+			return nil, err
+		}
+		return set, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// editor writes fields within a transaction. Reading fields only, as
+// CompareMetadata does, it can use the database instead.
+type editor struct {
+	tx      querier
+	columns map[string]*customColumn
+	dirtied *bool
+}
+
+func (e *editor) editBook(ctx context.Context, edit BookEdit) (*BookChange, error) {
+	change := &BookChange{BookID: edit.BookID, Changes: []FieldChange{}}
+	err := e.tx.QueryRowContext(ctx, "SELECT title FROM books WHERE id = ?", edit.BookID).Scan(&change.Title)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("book not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range edit.Fields {
+		old, err := e.get(ctx, edit.BookID, field.Field)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.Field, err)
+		}
+		if err := e.set(ctx, edit.BookID, field.Field, field.Value); err != nil {
+			return nil, fmt.Errorf("%s: %w", field.Field, err)
+		}
+		// Read the value back, as existing items keep their own case
+		value, err := e.get(ctx, edit.BookID, field.Field)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.Field, err)
+		}
+		if !reflect.DeepEqual(old, value) {
+			change.Changes = append(change.Changes, FieldChange{Field: field.Field, Old: old, New: value})
+		}
+	}
+
+	if len(change.Changes) == 0 {
+		return change, nil
+	}
+	if err := e.touch(ctx, edit.BookID); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// touch updates the last modification time of a book and marks it for
+// Calibre's metadata backup
+func (e *editor) touch(ctx context.Context, bookID int) error {
+	if _, err := e.tx.ExecContext(ctx, "UPDATE books SET last_modified = ? WHERE id = ?", calibreNow(), bookID); err != nil {
+		return err
+	}
+
+	if e.dirtied == nil {
+		exists, err := hasTable(ctx, e.tx, "metadata_dirtied")
+		if err != nil {
+			return err
+		}
+		e.dirtied = &exists
+	}
+	if *e.dirtied {
+		_, err := e.tx.ExecContext(ctx, "INSERT OR IGNORE INTO metadata_dirtied (book) VALUES (?)", bookID)
+		return err
+	}
+	return nil
+}
+
+// calibreNow returns the current time as Calibre stores it
+func calibreNow() string {
+	return time.Now().UTC().Format("2006-01-02 15:04:05.000000") + "+00:00"
+}
+
+// linkedItems describe the tables of the items shared between books
+type linkedItems struct {
+	table     string // items table, such as tags
+	column    string // name column of the items table
+	link      string // link table, such as books_tags_link
+	linkCol   string // column of the link table referring to the item
+	sortTitle bool   // whether the items have a title sort key
+}
+
+var (
+	authorItems    = linkedItems{"authors", "name", "books_authors_link", "author", false}
+	tagItems       = linkedItems{"tags", "name", "books_tags_link", "tag", false}
+	seriesItems    = linkedItems{"series", "name", "books_series_link", "series", true}
+	publisherItems = linkedItems{"publishers", "name", "books_publishers_link", "publisher", true}
+	languageItems  = linkedItems{"languages", "lang_code", "books_languages_link", "lang_code", false}
+)
+
+func (e *editor) get(ctx context.Context, bookID int, field string) (any, error) {
+	switch field {
+	case FieldTitle:
+		return e.queryString(ctx, "SELECT title FROM books WHERE id = ?", bookID)
+	case FieldAuthors:
+		return e.linkedNames(ctx, authorItems, bookID)
+	case FieldTags:
+		tags, err := e.linkedNames(ctx, tagItems, bookID)
+		sort.Slice(tags, func(i, j int) bool { return fold(tags[i]) < fold(tags[j]) })
+		return tags, err
+	case FieldSeries:
+		return e.linkedName(ctx, seriesItems, bookID)
+	case FieldPublisher:
+		return e.linkedName(ctx, publisherItems, bookID)
+	case FieldSeriesIndex:
+		var index float64
+		err := e.tx.QueryRowContext(ctx, "SELECT series_index FROM books WHERE id = ?", bookID).Scan(&index)
+		return index, err
+	case FieldRating:
+		var rating sql.NullInt64
+		err := e.tx.QueryRowContext(ctx, `
+			SELECT MAX(r.rating)
+			FROM books_ratings_link l
+			JOIN ratings r ON l.rating = r.id
+			WHERE l.book = ?
+		`, bookID).Scan(&rating)
+		return float64(rating.Int64) / 2, err
+	case FieldPubDate:
+		date, err := e.queryString(ctx, "SELECT COALESCE(strftime('%Y-%m-%d', pubdate), '') FROM books WHERE id = ?", bookID)
+		if strings.HasPrefix(date, "0101-") {
+			date = ""
+		}
+		return date, err
+	case FieldComments:
+		return e.queryString(ctx, "SELECT text FROM comments WHERE book = ?", bookID)
+	}
+
+	if typ, ok := strings.CutPrefix(field, identifierFieldPrefix); ok {
+		return e.queryString(ctx, "SELECT val FROM identifiers WHERE book = ? AND type = ?", bookID, strings.ToLower(typ))
+	}
+	if label, ok := strings.CutPrefix(field, "#"); ok {
+		column, err := e.column(ctx, label)
+		if err != nil {
+			return nil, err
+		}
+		return column.get(ctx, e.tx, bookID)
+	}
+	return nil, fmt.Errorf("unknown field")
+}
+
+func (e *editor) set(ctx context.Context, bookID int, field string, value any) error {
+	switch field {
+	case FieldTitle:
+		title, err := toString(value)
+		if err != nil {
+			return err
+		}
+		if title == "" {
+			return fmt.Errorf("title can't be empty")
+		}
+		_, err = e.tx.ExecContext(ctx, "UPDATE books SET title = ?, sort = ? WHERE id = ?", title, titleSort(title), bookID)
+		return err
+	case FieldAuthors:
+		authors, err := toStrings(value)
+		if err != nil {
+			return err
+		}
+		if len(authors) == 0 {
+			return fmt.Errorf("a book needs at least one author")
+		}
+		// Calibre separates authors with & and keeps commas out of names
+		for i, author := range authors {
+			authors[i] = strings.ReplaceAll(author, ",", "|")
+		}
+		ids, err := e.setLinkedNames(ctx, authorItems, bookID, authors)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("a book needs at least one author")
+		}
+		sorts := make([]string, len(ids))
+		for i, id := range ids {
+			if err := e.tx.QueryRowContext(ctx, "SELECT sort FROM authors WHERE id = ?", id).Scan(&sorts[i]); err != nil {
+				return err
+			}
+		}
+		_, err = e.tx.ExecContext(ctx, "UPDATE books SET author_sort = ? WHERE id = ?", strings.Join(sorts, " & "), bookID)
+		return err
+	case FieldTags:
+		tags, err := toStrings(value)
+		if err != nil {
+			return err
+		}
+		_, err = e.setLinkedNames(ctx, tagItems, bookID, tags)
+		return err
+	case FieldSeries, FieldPublisher:
+		name, err := toString(value)
+		if err != nil {
+			return err
+		}
+		items := seriesItems
+		if field == FieldPublisher {
+			items = publisherItems
+		}
+		var names []string
+		if name != "" {
+			names = []string{name}
+		}
+		_, err = e.setLinkedNames(ctx, items, bookID, names)
+		return err
+	case FieldSeriesIndex:
+		index, err := toFloat(value)
+		if err != nil {
+			return err
+		}
+		if index == nil {
+			one := 1.0
+			index = &one
+		}
+		_, err = e.tx.ExecContext(ctx, "UPDATE books SET series_index = ? WHERE id = ?", *index, bookID)
+		return err
+	case FieldRating:
+		stars, err := toFloat(value)
+		if err != nil {
+			return err
+		}
+		return e.setRating(ctx, bookID, stars)
+	case FieldPubDate:
+		date, err := toDate(value)
+		if err != nil {
+			return err
+		}
+		_, err = e.tx.ExecContext(ctx, "UPDATE books SET pubdate = ? WHERE id = ?", date, bookID)
+		return err
+	case FieldComments:
+		text, err := toString(value)
+		if err != nil {
+			return err
+		}
+		if text == "" {
+			_, err = e.tx.ExecContext(ctx, "DELETE FROM comments WHERE book = ?", bookID)
+			return err
+		}
+		_, err = e.tx.ExecContext(ctx, "INSERT OR REPLACE INTO comments (book, text) VALUES (?, ?)", bookID, text)
+		return err
+	}
+
+	if typ, ok := strings.CutPrefix(field, identifierFieldPrefix); ok {
+		typ = strings.ToLower(strings.TrimSpace(typ))
+		val, err := toString(value)
+		if err != nil {
+			return err
+		}
+		if typ == "" || strings.ContainsAny(typ, ":,") {
+			return fmt.Errorf("invalid identifier type %q", typ)
+		}
+		if val == "" {
+			_, err = e.tx.ExecContext(ctx, "DELETE FROM identifiers WHERE book = ? AND type = ?", bookID, typ)
+			return err
+		}
+		_, err = e.tx.ExecContext(ctx, "INSERT OR REPLACE INTO identifiers (book, type, val) VALUES (?, ?, ?)", bookID, typ, val)
+		return err
+	}
+	if label, ok := strings.CutPrefix(field, "#"); ok {
+		column, err := e.column(ctx, label)
+		if err != nil {
+			return err
+		}
+		return column.set(ctx, e.tx, bookID, value)
+	}
+	return fmt.Errorf("unknown field")
+}
+
+func (e *editor) column(ctx context.Context, label string) (*customColumn, error) {
+	if column, ok := e.columns[label]; ok {
+		return column, nil
+	}
+	column, err := getCustomColumn(ctx, e.tx, label)
+	if err != nil {
+		return nil, err
+	}
+	if column == nil {
+		return nil, fmt.Errorf("no custom column #%s", label)
+	}
+	e.columns[label] = column
+	return column, nil
+}
+
+func (e *editor) queryString(ctx context.Context, query string, args ...any) (string, error) {
+	var s sql.NullString
+	err := e.tx.QueryRowContext(ctx, query, args...).Scan(&s)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return s.String, err
+}
+
+func (e *editor) linkedNames(ctx context.Context, items linkedItems, bookID int) ([]string, error) {
+	rows, err := e.tx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT i.%s
+		FROM %s i
+		JOIN %s l ON i.id = l.%s
+		WHERE l.book = ?
+		ORDER BY l.id
+	`, items.column, items.table, items.link, items.linkCol), bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (e *editor) linkedName(ctx context.Context, items linkedItems, bookID int) (string, error) {
+	names, err := e.linkedNames(ctx, items, bookID)
+	if err != nil || len(names) == 0 {
+		return "", err
+	}
+	return names[0], nil
+}
+
+// setLinkedNames links a book to the named items in order, creating the
+// missing items and deleting the ones no book uses anymore. It returns the IDs
+// of the linked items, in order, once names are trimmed and deduplicated.
+func (e *editor) setLinkedNames(ctx context.Context, items linkedItems, bookID int, names []string) ([]int64, error) {
+	// Remember the current items to delete them if they become unused
+	rows, err := e.tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE book = ?", items.linkCol, items.link), bookID)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := e.tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE book = ?", items.link), bookID); err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[fold(name)] {
+			continue
+		}
+		seen[fold(name)] = true
+
+		id, err := e.itemID(ctx, items, name)
+		if err != nil {
+			return nil, err
+		}
+		// Names matched without case can still select the same item
+		if slices.Contains(ids, id) {
+			continue
+		}
+		ids = append(ids, id)
+		_, err = e.tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (book, %s) VALUES (?, ?)", items.link, items.linkCol), bookID, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range previous {
+		_, err := e.tx.ExecContext(ctx, fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE id = ? AND NOT EXISTS (SELECT 1 FROM %s WHERE %s = ?)
+		`, items.table, items.link, items.linkCol), id, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// itemID returns the ID of the named item, creating it if needed. Names are
+// matched ignoring case, as Calibre does.
+func (e *editor) itemID(ctx context.Context, items linkedItems, name string) (int64, error) {
+	var id int64
+	err := e.tx.QueryRowContext(ctx, fmt.Sprintf("SELECT id FROM %s WHERE %s = ? COLLATE NOCASE", items.table, items.column), name).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	var res sql.Result
+	switch {
+	case items == authorItems:
+		res, err = e.tx.ExecContext(ctx, "INSERT INTO authors (name, sort) VALUES (?, ?)", name,
+			authorSort(strings.ReplaceAll(name, "|", ",")))
+	case items.sortTitle:
+		res, err = e.tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s, sort) VALUES (?, ?)", items.table, items.column), name, titleSort(name))
+	default:
+		res, err = e.tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (?)", items.table, items.column), name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// setRating links a book to a rating, stored by Calibre as half stars from 0
+// to 10. A nil or zero rating removes it.
+func (e *editor) setRating(ctx context.Context, bookID int, stars *float64) error {
+	if _, err := e.tx.ExecContext(ctx, "DELETE FROM books_ratings_link WHERE book = ?", bookID); err != nil {
+		return err
+	}
+	if stars == nil || *stars == 0 {
+		return nil
+	}
+	if *stars < 0 || *stars > 5 {
+		return fmt.Errorf("rating must be between 0 and 5 stars")
+	}
+
+	rating := int(math.Round(*stars * 2))
+	if _, err := e.tx.ExecContext(ctx, "INSERT OR IGNORE INTO ratings (rating) VALUES (?)", rating); err != nil {
+		return err
+	}
+	_, err := e.tx.ExecContext(ctx, `
+		INSERT INTO books_ratings_link (book, rating)
+		SELECT ?, id FROM ratings WHERE rating = ?
+	`, bookID, rating)
+	return err
+}
+
+func toString(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return strings.TrimSpace(v), nil
+	}
+	return "", fmt.Errorf("expected a string, got %v", value)
+}
+
+func toStrings(value any) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return []string{}, nil
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return []string{}, nil
+		}
+		return []string{strings.TrimSpace(v)}, nil
+	case []string:
+		return append([]string{}, v...), nil
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a list of strings, got %v", value)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("expected a list of strings, got %v", value)
+}
+
+func toFloat(value any) (*float64, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case float64:
+		return &v, nil
+	case int:
+		f := float64(v)
+		return &f, nil
+	case int64:
+		f := float64(v)
+		return &f, nil
+	}
+	return nil, fmt.Errorf("expected a number, got %v", value)
+}
+
+// toDate converts a YYYY-MM-DD date to the form Calibre stores
+func toDate(value any) (string, error) {
+	s, err := toString(value)
+	if err != nil {
+		return "", err
+	}
+	if s == "" {
+		return undefinedDate, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return "", fmt.Errorf("expected a date as YYYY-MM-DD, got %q", s)
+	}
+	return t.Format("2006-01-02") + " 00:00:00+00:00", nil
+}
@@ -0,0 +1,740 @@
+package calibre
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dcNamespace  = "http://purl.org/dc/elements/1.1/"
+	opfNamespace = "http://www.idpf.org/2007/opf"
+)
+
+// EmbedResult describes the metadata written into the EPUB of a book. File
+// and Original are relative to the library, Original being set when the
+// original file was kept by this call. Metadata lists the OPF elements that
+// replaced the ones of the file, and Skipped the parts of the metadata that
+// could not be written. ChangeID is the ID of the change in the journal, and
+// Warning tells why there is none when the metadata was embedded.
+type EmbedResult struct {
+	BookID   int      `json:"book_id"`
+	File     string   `json:"file"`
+	Original string   `json:"original,omitempty"`
+	Metadata string   `json:"metadata"`
+	Skipped  []string `json:"skipped,omitempty"`
+	ChangeID int      `json:"change_id,omitempty"`
+	DryRun   bool     `json:"dry_run,omitempty"`
+	Warning  string   `json:"warning,omitempty"`
+}
+
+// embeddedMetadata is the metadata of a book that is written into its EPUB
+type embeddedMetadata struct {
+	title       string
+	titleSort   string
+	authors     []embeddedAuthor
+	tags        []string
+	series      string
+	seriesIndex float64
+	identifiers map[string]string
+	uuid        string
+	cover       []byte
+}
+
+type embeddedAuthor struct {
+	name string
+	sort string
+}
+
+// EmbedMetadata writes the metadata of a book from metadata.db into the OPF
+// of its EPUB, as Calibre does when saving to disk. The title, authors with
+// their sort names, tags, identifiers, series and cover replace the ones of
+// the file, while its other metadata is kept. The EPUB is zipped again with
+// the mimetype entry first and uncompressed. The first time, the original
+// file is kept as the ORIGINAL_EPUB format of the book, as Calibre's polishing
+// does. The embedding is recorded in the journal of the DB if it has one, but
+// can't be reverted.
+func EmbedMetadata(ctx context.Context, db *DB, libraryPath string, bookID int, dryRun bool) (*EmbedResult, error) {
+	meta, err := readEmbeddedMetadata(ctx, db, libraryPath, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookPath, name string
+	err = db.QueryRowContext(ctx, `
+		SELECT b.path, d.name
+		FROM books b
+		JOIN data d ON b.id = d.book
+		WHERE b.id = ? AND d.format = 'EPUB'
+		LIMIT 1
+	`, bookID).Scan(&bookPath, &name)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("book %d has no EPUB", bookID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	epubPath := filepath.Join(libraryPath, bookPath, name+".epub")
+	result := &EmbedResult{BookID: bookID, File: path.Join(bookPath, name+".epub"), DryRun: dryRun}
+
+	// Write the new EPUB next to the original, which is only replaced once
+	// metadata.db is updated
+	var tmpPath string
+	err = func() error {
+		r, err := zip.OpenReader(epubPath)
+		if err != nil {
+			return fmt.Errorf("failed to open EPUB: %w", err)
+		}
+		defer r.Close()
+
+		files, metadata, skipped, err := rewriteEPUBMetadata(r, meta)
+		if err != nil {
+			return err
+		}
+		result.Metadata, result.Skipped = metadata, skipped
+		if dryRun {
+			return nil
+		}
+		tmpPath, err = writeEPUB(epubPath, &r.Reader, files)
+		return err
+	}()
+	if err != nil || dryRun {
+		return result, err
+	}
+	defer os.Remove(tmpPath)
+
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	var hasOriginal bool
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM data WHERE book = ? AND format = 'ORIGINAL_EPUB'", bookID).
+		Scan(&hasOriginal)
+	if err != nil {
+		return nil, err
+	}
+
+	var originalPath string
+	set, err := runEdit(ctx, db, editOptions{commit: true}, func(e *editor, set *ChangeSet) error {
+		_, err := e.tx.ExecContext(ctx, "UPDATE data SET uncompressed_size = ? WHERE book = ? AND format = 'EPUB'", info.Size(), bookID)
+		if err != nil {
+			return err
+		}
+		change := BookChange{BookID: bookID, Title: meta.title, Changes: []FieldChange{
+			{Field: "epub_metadata", Old: nil, New: result.Metadata},
+		}}
+		if !hasOriginal {
+			// Kept before the transaction is committed, and removed below if
+			// it fails
+			originalPath = filepath.Join(libraryPath, bookPath, name+".original_epub")
+			size, err := copyFile(epubPath, originalPath)
+			if err != nil {
+				originalPath = ""
+				return fmt.Errorf("keeping the original EPUB: %w", err)
+			}
+			_, err = e.tx.ExecContext(ctx, `
+				INSERT INTO data (book, format, uncompressed_size, name)
+				VALUES (?, 'ORIGINAL_EPUB', ?, ?)
+			`, bookID, size, name)
+			if err != nil {
+				return err
+			}
+			result.Original = path.Join(bookPath, name+".original_epub")
+			change.Changes = append(change.Changes, FieldChange{Field: "original_epub", Old: nil, New: result.Original})
+		}
+		set.Embedded = []int{bookID}
+		set.Changes = append(set.Changes, change)
+		return nil
+	})
+	// Once committed, the data rows describe the new EPUB and the kept
+	// original even if the change was not journaled
+	var journalErr *JournalError
+	if errors.As(err, &journalErr) {
+		result.Warning = err.Error()
+	} else if err != nil {
+		if originalPath != "" {
+			os.Remove(originalPath)
+		}
+		return nil, err
+	}
+	result.ChangeID = set.ID
+
+	if err := os.Rename(tmpPath, epubPath); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// readEmbeddedMetadata reads the metadata of a book that EmbedMetadata writes
+func readEmbeddedMetadata(ctx context.Context, db *DB, libraryPath string, bookID int) (*embeddedMetadata, error) {
+	meta := &embeddedMetadata{}
+	var sortTitle, uuid, series sql.NullString
+	var bookPath string
+	var hasCover bool
+	err := db.QueryRowContext(ctx, `
+		SELECT b.title, b.sort, b.uuid, b.path, b.has_cover, b.series_index, s.name
+		FROM books b
+		LEFT JOIN books_series_link bsl ON b.id = bsl.book
+		LEFT JOIN series s ON bsl.series = s.id
+		WHERE b.id = ?
+	`, bookID).Scan(&meta.title, &sortTitle, &uuid, &bookPath, &hasCover, &meta.seriesIndex, &series)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("book not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta.titleSort = sortTitle.String
+	if meta.titleSort == "" {
+		meta.titleSort = titleSort(meta.title)
+	}
+	meta.uuid = uuid.String
+	meta.series = series.String
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.name, a.sort
+		FROM authors a
+		JOIN books_authors_link bal ON a.id = bal.author
+		WHERE bal.book = ?
+		ORDER BY bal.id
+	`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var author embeddedAuthor
+		var sortName sql.NullString
+		if err := rows.Scan(&author.name, &sortName); err != nil {
+			return nil, err
+		}
+		// Calibre keeps commas out of author names in the database only
+		author.name = strings.ReplaceAll(author.name, "|", ",")
+		author.sort = sortName.String
+		if author.sort == "" {
+			author.sort = authorSort(author.name)
+		}
+		meta.authors = append(meta.authors, author)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if meta.tags, err = getTagsForBook(db, bookID); err != nil {
+		return nil, err
+	}
+	if meta.identifiers, err = getIdentifiersForBook(db, bookID); err != nil {
+		return nil, err
+	}
+	if hasCover {
+		meta.cover, err = os.ReadFile(filepath.Join(libraryPath, bookPath, coverFileName))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read cover: %w", err)
+		}
+	}
+	return meta, nil
+}
+
+// opfDocument is an OPF package document scanned for the byte ranges of the
+// children of its metadata element, so that they can be replaced without
+// touching the rest of the document
+type opfDocument struct {
+	data     []byte
+	version  string
+	uniqueID string
+	// prefixes maps namespaces to the prefixes declared for them
+	prefixes         map[string]string
+	defaultNamespace string
+	ids              map[string]bool
+
+	metadata       []opfElement
+	metadataEnd    int
+	metadataIndent string
+	manifestEnd    int
+	manifestIndent string
+}
+
+// opfElement is a child of the metadata element. Its byte range includes
+// the whitespace before it, so that removing it keeps the layout.
+type opfElement struct {
+	xml.StartElement
+	from int
+	to   int
+	text string
+}
+
+func scanOPF(data []byte) (*opfDocument, error) {
+	doc := &opfDocument{
+		data:           data,
+		prefixes:       make(map[string]string),
+		ids:            make(map[string]bool),
+		metadataIndent: "\n",
+		manifestIndent: "\n",
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var stack []string
+	var current *opfElement
+	space := -1 // offset of the whitespace before the current token
+	indent := ""
+	for {
+		offset := int(d.InputOffset())
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OPF: %w", err)
+		}
+		// Only the children of metadata and manifest are of interest
+		container := ""
+		if len(stack) >= 2 {
+			container = stack[1]
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if id := attr(t, "id"); id != "" {
+				doc.ids[id] = true
+			}
+			switch {
+			case len(stack) == 0:
+				doc.version = attr(t, "version")
+				doc.uniqueID = attr(t, "unique-identifier")
+				doc.declare(t)
+			case len(stack) == 1 && t.Name.Local == "metadata":
+				doc.declare(t)
+			case len(stack) == 2 && container == "metadata":
+				current = &opfElement{StartElement: t.Copy(), from: offset}
+				if space >= 0 {
+					current.from = space
+				}
+			}
+			// New children are indented like the existing ones
+			if indent != "" && container == "metadata" {
+				doc.metadataIndent = indent
+			} else if indent != "" && container == "manifest" {
+				doc.manifestIndent = indent
+			}
+			stack = append(stack, t.Name.Local)
+			space, indent = -1, ""
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			end := offset
+			if space >= 0 {
+				end = space
+			}
+			switch {
+			case len(stack) == 2 && container == "metadata" && current != nil:
+				current.to = int(d.InputOffset())
+				doc.metadata = append(doc.metadata, *current)
+				current = nil
+			case len(stack) == 1 && t.Name.Local == "metadata":
+				doc.metadataEnd = end
+			case len(stack) == 1 && t.Name.Local == "manifest":
+				doc.manifestEnd = end
+			}
+			space, indent = -1, ""
+		case xml.CharData:
+			if len(stack) == 2 && len(bytes.TrimSpace(t)) == 0 {
+				space = offset
+				if i := bytes.LastIndexByte(t, '\n'); i >= 0 {
+					indent = string(t[i:])
+				}
+				continue
+			}
+			if len(stack) == 3 && current != nil {
+				current.text += string(t)
+			}
+			space, indent = -1, ""
+		default:
+			space, indent = -1, ""
+		}
+	}
+
+	// Elements are added before the end tags, which self-closing elements
+	// don't have
+	for _, end := range []int{doc.metadataEnd, doc.manifestEnd} {
+		if end == 0 || !bytes.HasPrefix(bytes.TrimLeft(data[end:], " \t\r\n"), []byte("</")) {
+			return nil, fmt.Errorf("OPF has no metadata or manifest to update")
+		}
+	}
+	return doc, nil
+}
+
+// declare records the namespaces declared by an element
+func (doc *opfDocument) declare(el xml.StartElement) {
+	for _, a := range el.Attr {
+		switch {
+		case a.Name.Space == "xmlns":
+			doc.prefixes[a.Value] = a.Name.Local
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			doc.defaultNamespace = a.Value
+		}
+	}
+}
+
+// newID returns an element ID based on name that the document doesn't use yet
+func (doc *opfDocument) newID(name string) string {
+	id := name
+	for i := 1; doc.ids[id]; i++ {
+		id = fmt.Sprintf("%s%d", name, i)
+	}
+	doc.ids[id] = true
+	return id
+}
+
+// element formats an element with the prefixes declared by the document,
+// declaring the namespaces it doesn't
+func (doc *opfDocument) element(name xml.Name, attrs []xml.Attr, text string) string {
+	var declarations []string
+	qualify := func(name xml.Name, isAttr bool) string {
+		if name.Space == "" || (!isAttr && name.Space == doc.defaultNamespace) {
+			return name.Local
+		}
+		if prefix, ok := doc.prefixes[name.Space]; ok {
+			return prefix + ":" + name.Local
+		}
+		if !isAttr {
+			declarations = append(declarations, fmt.Sprintf(` xmlns="%s"`, name.Space))
+			return name.Local
+		}
+		// Attributes need a prefix, as the default namespace doesn't apply
+		// to them
+		declaration := fmt.Sprintf(` xmlns:opf="%s"`, name.Space)
+		if !strings.Contains(strings.Join(declarations, ""), declaration) {
+			declarations = append(declarations, declaration)
+		}
+		return "opf:" + name.Local
+	}
+
+	var b strings.Builder
+	tag := qualify(name, false)
+	b.WriteString("<" + tag)
+	for _, a := range attrs {
+		b.WriteString(" " + qualify(a.Name, true) + `="` + escapeXML(a.Value) + `"`)
+	}
+	b.WriteString(strings.Join(declarations, ""))
+	if text == "" {
+		b.WriteString("/>")
+	} else {
+		b.WriteString(">" + escapeXML(text) + "</" + tag + ">")
+	}
+	return b.String()
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// rewriteEPUBMetadata returns the files of an EPUB that change when its
+// metadata is replaced with meta, by path in the archive, along with the new
+// metadata elements and the parts of meta that were left out
+func rewriteEPUBMetadata(r *zip.ReadCloser, meta *embeddedMetadata) (map[string][]byte, string, []string, error) {
+	pkg, opfPath, err := readPackage(r)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	data, err := readOPF(r, opfPath)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	doc, err := scanOPF(data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	epub3 := strings.HasPrefix(doc.version, "3")
+	files := make(map[string][]byte)
+
+	dc := func(local string) xml.Name { return xml.Name{Space: dcNamespace, Local: local} }
+	metaName := xml.Name{Space: opfNamespace, Local: "meta"}
+	plain := func(name string, value string) xml.Attr { return xml.Attr{Name: xml.Name{Local: name}, Value: value} }
+	opf := func(name string, value string) xml.Attr {
+		return xml.Attr{Name: xml.Name{Space: opfNamespace, Local: name}, Value: value}
+	}
+
+	// Replace the cover image declared by the EPUB, or declare a new one
+	var elements, manifestItems []string
+	var skipped []string
+	newCover := false
+	if meta.cover != nil {
+		if item, ok := pkg.coverItem(); ok {
+			// The rest of the metadata is still written when the cover can't be
+			cover, err := encodeCover(meta.cover, item.MediaType)
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("cover: %v", err))
+			} else {
+				files[resolveHref(opfPath, item.Href)] = cover
+			}
+		} else {
+			newCover = true
+			href := "cover.jpg"
+			for i := 1; ; i++ {
+				if _, err := r.Open(resolveHref(opfPath, href)); err != nil {
+					break
+				}
+				href = fmt.Sprintf("cover%d.jpg", i)
+			}
+			files[resolveHref(opfPath, href)] = meta.cover
+
+			id := doc.newID("cover")
+			attrs := []xml.Attr{plain("id", id), plain("href", href), plain("media-type", "image/jpeg")}
+			if epub3 {
+				attrs = append(attrs, plain("properties", "cover-image"))
+			}
+			manifestItems = append(manifestItems, doc.element(xml.Name{Space: opfNamespace, Local: "item"}, attrs, ""))
+			elements = append(elements, doc.element(metaName, []xml.Attr{plain("name", "cover"), plain("content", id)}, ""))
+		}
+	}
+
+	// Drop the elements replaced by the metadata of the book, along with the
+	// EPUB 3 meta elements refining them
+	roles := make(map[string]string)
+	for _, el := range doc.metadata {
+		if el.Name.Local == "meta" && attr(el.StartElement, "property") == "role" {
+			roles[strings.TrimPrefix(attr(el.StartElement, "refines"), "#")] = strings.TrimSpace(el.text)
+		}
+	}
+	uniqueIdentifier := ""
+	removed := make(map[int]bool)
+	removedIDs := make(map[string]bool)
+	for i, el := range doc.metadata {
+		id := attr(el.StartElement, "id")
+		switch el.Name.Local {
+		case "title", "subject":
+			removed[i] = true
+		case "creator":
+			role := attr(el.StartElement, "role")
+			if role == "" {
+				role = roles[id]
+			}
+			removed[i] = role == "" || role == "aut"
+		case "identifier":
+			removed[i] = id == "" || id != doc.uniqueID
+			if !removed[i] {
+				uniqueIdentifier = el.text
+			}
+		case "meta":
+			switch attr(el.StartElement, "name") {
+			case "calibre:series", "calibre:series_index", "calibre:title_sort":
+				removed[i] = true
+			case "cover":
+				removed[i] = newCover
+			}
+			if epub3 && attr(el.StartElement, "property") == "dcterms:modified" {
+				removed[i] = true
+			}
+		}
+		if removed[i] && id != "" {
+			removedIDs[id] = true
+		}
+	}
+	for i, el := range doc.metadata {
+		if el.Name.Local == "meta" && removedIDs[strings.TrimPrefix(attr(el.StartElement, "refines"), "#")] {
+			removed[i] = true
+		}
+	}
+
+	// Write the metadata of the book the way Calibre does for each version
+	elements = append(elements, doc.element(dc("title"), nil, meta.title))
+	for _, author := range meta.authors {
+		if !epub3 {
+			elements = append(elements, doc.element(dc("creator"),
+				[]xml.Attr{opf("file-as", author.sort), opf("role", "aut")}, author.name))
+			continue
+		}
+		id := doc.newID("creator")
+		elements = append(elements,
+			doc.element(dc("creator"), []xml.Attr{plain("id", id)}, author.name),
+			doc.element(metaName, []xml.Attr{plain("refines", "#"+id), plain("property", "file-as")}, author.sort),
+			doc.element(metaName, []xml.Attr{plain("refines", "#"+id), plain("property", "role"),
+				plain("scheme", "marc:relators")}, "aut"),
+		)
+	}
+	for _, tag := range meta.tags {
+		elements = append(elements, doc.element(dc("subject"), nil, tag))
+	}
+	identifiers := make(map[string]string)
+	for typ, val := range meta.identifiers {
+		identifiers[typ] = val
+	}
+	if meta.uuid != "" && !strings.Contains(uniqueIdentifier, meta.uuid) {
+		identifiers["uuid"] = meta.uuid
+	}
+	for _, typ := range sortedMapKeys(identifiers) {
+		val := identifiers[typ]
+		switch {
+		case !epub3 && typ == "uuid":
+			elements = append(elements, doc.element(dc("identifier"), []xml.Attr{opf("scheme", "uuid")}, val))
+		case !epub3:
+			elements = append(elements, doc.element(dc("identifier"), []xml.Attr{opf("scheme", strings.ToUpper(typ))}, val))
+		case typ == "isbn" || typ == "uuid":
+			elements = append(elements, doc.element(dc("identifier"), nil, "urn:"+typ+":"+val))
+		default:
+			elements = append(elements, doc.element(dc("identifier"), nil, typ+":"+val))
+		}
+	}
+	if meta.series != "" {
+		elements = append(elements,
+			doc.element(metaName, []xml.Attr{plain("name", "calibre:series"), plain("content", meta.series)}, ""),
+			doc.element(metaName, []xml.Attr{plain("name", "calibre:series_index"),
+				plain("content", strconv.FormatFloat(meta.seriesIndex, 'f', -1, 64))}, ""),
+		)
+	}
+	elements = append(elements,
+		doc.element(metaName, []xml.Attr{plain("name", "calibre:title_sort"), plain("content", meta.titleSort)}, ""))
+	if epub3 {
+		elements = append(elements, doc.element(metaName, []xml.Attr{plain("property", "dcterms:modified")},
+			time.Now().UTC().Format("2006-01-02T15:04:05Z")))
+	}
+
+	// Splice the changes into the document
+	type splice struct {
+		from, to int
+		text     string
+	}
+	var splices []splice
+	for i, el := range doc.metadata {
+		if removed[i] {
+			splices = append(splices, splice{from: el.from, to: el.to})
+		}
+	}
+	splices = append(splices, splice{
+		from: doc.metadataEnd, to: doc.metadataEnd,
+		text: doc.metadataIndent + strings.Join(elements, doc.metadataIndent),
+	})
+	if len(manifestItems) > 0 {
+		splices = append(splices, splice{
+			from: doc.manifestEnd, to: doc.manifestEnd,
+			text: doc.manifestIndent + strings.Join(manifestItems, doc.manifestIndent),
+		})
+	}
+	sort.SliceStable(splices, func(i, j int) bool { return splices[i].from < splices[j].from })
+
+	var out bytes.Buffer
+	pos := 0
+	for _, s := range splices {
+		out.Write(data[pos:s.from])
+		out.WriteString(s.text)
+		pos = s.to
+	}
+	out.Write(data[pos:])
+	files[opfPath] = out.Bytes()
+
+	return files, strings.Join(elements, "\n"), skipped, nil
+}
+
+// encodeCover encodes the JPEG cover of a book as the media type of the cover
+// image it replaces
+func encodeCover(cover []byte, mediaType string) ([]byte, error) {
+	switch mediaType {
+	case "image/jpeg":
+		return cover, nil
+	case "image/png":
+		img, _, err := image.Decode(bytes.NewReader(cover))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode cover: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode cover: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("the cover image of the EPUB is %s, which can't be replaced", mediaType)
+}
+
+// writeEPUB writes a copy of an EPUB with some files replaced or added, next
+// to epubPath, and returns its location. The mimetype entry comes first and is
+// stored uncompressed, as the EPUB container format requires.
+func writeEPUB(epubPath string, r *zip.Reader, files map[string][]byte) (string, error) {
+	info, err := os.Stat(epubPath)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(epubPath), ".calibre-mcp-*.epub")
+	if err != nil {
+		return "", err
+	}
+	err = func() error {
+		if err := f.Chmod(info.Mode().Perm()); err != nil {
+			return err
+		}
+		w := zip.NewWriter(f)
+		mimetype := []byte("application/epub+zip")
+		mw, err := w.CreateRaw(&zip.FileHeader{
+			Name:               "mimetype",
+			Method:             zip.Store,
+			CRC32:              crc32.ChecksumIEEE(mimetype),
+			CompressedSize64:   uint64(len(mimetype)),
+			UncompressedSize64: uint64(len(mimetype)),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := mw.Write(mimetype); err != nil {
+			return err
+		}
+
+		written := make(map[string]bool)
+		write := func(name string) error {
+			fw, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+			if err != nil {
+				return err
+			}
+			written[name] = true
+			_, err = fw.Write(files[name])
+			return err
+		}
+		for _, file := range r.File {
+			if file.Name == "mimetype" {
+				continue
+			}
+			if _, ok := files[file.Name]; ok {
+				if err := write(file.Name); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := w.Copy(file); err != nil {
+				return err
+			}
+		}
+		for _, name := range sortedMapKeys(files) {
+			if !written[name] {
+				if err := write(name); err != nil {
+					return err
+				}
+			}
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
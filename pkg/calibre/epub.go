@@ -5,9 +5,15 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"net/url"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Chapter struct {
@@ -16,10 +22,16 @@ type Chapter struct {
 	Href  string `json:"href"`
 }
 
+// SearchMatch is a paragraph of a chapter that contains a search. Offset is
+// the position of the match in the chapter text, and CFI and ParagraphCFI the
+// ranges of the match and of the paragraph, in the form of Calibre's viewer.
 type SearchMatch struct {
 	ChapterIndex int    `json:"chapter_index"`
 	ChapterTitle string `json:"chapter_title"`
 	Snippet      string `json:"snippet"`
+	Offset       int    `json:"offset"`
+	CFI          string `json:"cfi"`
+	ParagraphCFI string `json:"paragraph_cfi"`
 }
 
 type searchCacheEntry struct {
@@ -27,7 +39,17 @@ type searchCacheEntry struct {
 	timestamp time.Time
 }
 
-var searchCache = make(map[string]searchCacheEntry)
+var (
+	searchCache   = make(map[string]searchCacheEntry)
+	searchCacheMu sync.Mutex
+)
+
+// ClearCaches drops cached search results, for use when the library changes
+func ClearCaches() {
+	searchCacheMu.Lock()
+	defer searchCacheMu.Unlock()
+	clear(searchCache)
+}
 
 type Container struct {
 	XMLName   xml.Name   `xml:"container"`
@@ -40,10 +62,73 @@ type Rootfile struct {
 
 type Package struct {
 	XMLName  xml.Name `xml:"package"`
+	Metadata Metadata `xml:"metadata"`
 	Manifest Manifest `xml:"manifest"`
 	Spine    Spine    `xml:"spine"`
 }
 
+// Metadata is the metadata element of an OPF package document: its Dublin
+// Core elements, and the meta elements of EPUB 2 and EPUB 3
+type Metadata struct {
+	Titles       []DCElement  `xml:"title"`
+	Creators     []Creator    `xml:"creator"`
+	Contributors []Creator    `xml:"contributor"`
+	Subjects     []DCElement  `xml:"subject"`
+	Description  string       `xml:"description"`
+	Publishers   []DCElement  `xml:"publisher"`
+	Dates        []Date       `xml:"date"`
+	Languages    []string     `xml:"language"`
+	Identifiers  []Identifier `xml:"identifier"`
+	Rights       []DCElement  `xml:"rights"`
+	Sources      []DCElement  `xml:"source"`
+	Metas        []Meta       `xml:"meta"`
+}
+
+// DCElement is a Dublin Core element. EPUB 3 meta elements refine it through
+// its ID.
+type DCElement struct {
+	ID    string `xml:"id,attr"`
+	Lang  string `xml:"lang,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Creator is a dc:creator or dc:contributor element. Role is the EPUB 2
+// opf:role attribute, such as aut for authors.
+type Creator struct {
+	ID     string `xml:"id,attr"`
+	Role   string `xml:"role,attr"`
+	FileAs string `xml:"file-as,attr"`
+	Name   string `xml:",chardata"`
+}
+
+// Identifier is a dc:identifier element, with the EPUB 2 opf:scheme attribute
+type Identifier struct {
+	ID     string `xml:"id,attr"`
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// Date is a dc:date element, with the EPUB 2 opf:event attribute, such as
+// publication
+type Date struct {
+	ID    string `xml:"id,attr"`
+	Event string `xml:"event,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Meta is a meta element. EPUB 2 ones have a name and a content, such as
+// calibre:series, while EPUB 3 ones have a property and a value and may refine
+// another element.
+type Meta struct {
+	ID       string `xml:"id,attr"`
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	Scheme   string `xml:"scheme,attr"`
+	Value    string `xml:",chardata"`
+}
+
 type Spine struct {
 	Itemrefs []Itemref `xml:"itemref"`
 }
@@ -57,8 +142,10 @@ type Manifest struct {
 }
 
 type Item struct {
-	Id   string `xml:"id,attr"`
-	Href string `xml:"href,attr"`
+	Id         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
 }
 
 func GetEPUBChapters(db *DB, libraryPath string, bookID int) ([]Chapter, error) {
@@ -73,39 +160,9 @@ func GetEPUBChapters(db *DB, libraryPath string, bookID int) ([]Chapter, error)
 	}
 	defer r.Close()
 
-	// Read container.xml
-	containerFile, err := r.Open("META-INF/container.xml")
+	pkg, opfPath, err := readPackage(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open container.xml: %w", err)
-	}
-	defer containerFile.Close()
-
-	var container Container
-	if err := xml.NewDecoder(containerFile).Decode(&container); err != nil {
-		return nil, fmt.Errorf("failed to parse container.xml: %w", err)
-	}
-
-	if len(container.Rootfiles) == 0 {
-		return nil, fmt.Errorf("no rootfile found")
-	}
-
-	opfPath := container.Rootfiles[0].Path
-
-	// Read content.opf
-	opfFile, err := r.Open(opfPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open OPF: %w", err)
-	}
-	defer opfFile.Close()
-
-	data, err := io.ReadAll(opfFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read OPF: %w", err)
-	}
-
-	var pkg Package
-	if err := xml.Unmarshal(data, &pkg); err != nil {
-		return nil, fmt.Errorf("failed to parse OPF: %w", err)
+		return nil, err
 	}
 
 	// Build href map
@@ -123,15 +180,10 @@ func GetEPUBChapters(db *DB, libraryPath string, bookID int) ([]Chapter, error)
 		}
 		title := fmt.Sprintf("Chapter %d", i+1)
 		// Try to extract title from the chapter file
-		chapterFile, err := r.Open(href)
-		if err == nil {
-			data, err := io.ReadAll(chapterFile)
-			chapterFile.Close()
-			if err == nil {
-				extractedTitle := extractTitleFromHTML(string(data))
-				if extractedTitle != "" {
-					title = extractedTitle
-				}
+		if _, data, err := readChapterFile(r, opfPath, href); err == nil {
+			extractedTitle := extractTitleFromHTML(string(data))
+			if extractedTitle != "" {
+				title = extractedTitle
 			}
 		}
 		chapters = append(chapters, Chapter{
@@ -144,91 +196,78 @@ func GetEPUBChapters(db *DB, libraryPath string, bookID int) ([]Chapter, error)
 	return chapters, nil
 }
 
+// GetEPUBChapterContent returns the text of a chapter, with a placeholder
+// where each image was. It is the text that search matches, annotations and
+// reading positions give offsets in.
 func GetEPUBChapterContent(db *DB, libraryPath string, bookID int, chapterIndex int) (string, error) {
-	epubPath, err := getEPUBPath(db, libraryPath, bookID)
+	r, err := openEPUBReader(db, libraryPath, bookID)
 	if err != nil {
 		return "", err
 	}
-
-	r, err := zip.OpenReader(epubPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open EPUB: %w", err)
-	}
 	defer r.Close()
 
-	chapters, err := GetEPUBChapters(db, libraryPath, bookID)
+	doc, err := r.chapter(chapterIndex)
 	if err != nil {
 		return "", err
 	}
 
-	if chapterIndex < 0 || chapterIndex >= len(chapters) {
-		return "", fmt.Errorf("chapter index out of range")
-	}
-
-	chapter := chapters[chapterIndex]
-
-	// Open the chapter file
-	chapterFile, err := r.Open(chapter.Href)
-	if err != nil {
-		return "", fmt.Errorf("failed to open chapter: %w", err)
-	}
-	defer chapterFile.Close()
-
-	data, err := io.ReadAll(chapterFile)
-	if err != nil {
-		return "", fmt.Errorf("failed to read chapter: %w", err)
-	}
-
-	// Extract text from XHTML
-	content := extractTextFromHTML(string(data))
-
-	return content, nil
+	return string(doc.text), nil
 }
 
 func SearchEPUBContent(db *DB, libraryPath string, bookID int, query string, limit int, offset int) ([]SearchMatch, error) {
 	key := fmt.Sprintf("%d:%s", bookID, query)
 	var matches []SearchMatch
 
-	if entry, ok := searchCache[key]; ok && time.Since(entry.timestamp) < time.Minute {
+	searchCacheMu.Lock()
+	entry, ok := searchCache[key]
+	searchCacheMu.Unlock()
+	if ok && time.Since(entry.timestamp) < time.Minute {
 		matches = entry.matches
 	} else {
-		chapters, err := GetEPUBChapters(db, libraryPath, bookID)
+		r, err := openEPUBReader(db, libraryPath, bookID)
 		if err != nil {
 			return nil, err
 		}
 
 		matches = make([]SearchMatch, 0)
-		queryLower := strings.ToLower(query)
+		queryLower := strings.Map(unicode.ToLower, query)
+		queryLength := utf8.RuneCountInString(query)
 
-		for _, chapter := range chapters {
-			content, err := GetEPUBChapterContent(db, libraryPath, bookID, chapter.Index)
+		for index := range r.pkg.Spine.Itemrefs {
+			doc, err := r.chapter(index)
 			if err != nil {
 				continue // skip chapters that can't be read
 			}
 
-			paragraphs := strings.Split(content, "\n")
-			for _, para := range paragraphs {
-				if para == "" {
-					continue
+			// Lowercasing rune by rune keeps the positions of the text
+			textLower := []rune(strings.Map(unicode.ToLower, string(doc.text)))
+			for start := 0; start < len(doc.text); {
+				end := start
+				for end < len(doc.text) && doc.text[end] != '\n' {
+					end++
 				}
-				paraLower := strings.ToLower(para)
-				if strings.Contains(paraLower, queryLower) {
-					// Find the position of the query in the paragraph
-					pos := strings.Index(paraLower, queryLower)
-					if pos != -1 {
-						// Highlight the match in the paragraph
-						snippet := para[:pos] + "**" + para[pos:pos+len(query)] + "**" + para[pos+len(query):]
-						matches = append(matches, SearchMatch{
-							ChapterIndex: chapter.Index,
-							ChapterTitle: chapter.Title,
-							Snippet:      snippet,
-						})
-					}
+				paraLower := string(textLower[start:end])
+				if pos := strings.Index(paraLower, queryLower); pos != -1 {
+					// Highlight the match in the paragraph
+					offset := start + utf8.RuneCountInString(paraLower[:pos])
+					matches = append(matches, SearchMatch{
+						ChapterIndex: doc.index,
+						ChapterTitle: doc.title,
+						Snippet: string(doc.text[start:offset]) + "**" + string(doc.text[offset:offset+queryLength]) +
+							"**" + string(doc.text[offset+queryLength:end]),
+						Offset:       offset,
+						CFI:          doc.cfiRange(offset, offset+queryLength),
+						ParagraphCFI: doc.cfiRange(start, end),
+					})
 				}
+				start = end + 1
 			}
 		}
+		r.Close()
 
+		searchCacheMu.Lock()
 		searchCache[key] = searchCacheEntry{matches: matches, timestamp: time.Now()}
+		searchCacheMu.Unlock()
 	}
 
 	if offset > 0 {
@@ -244,20 +283,107 @@ func SearchEPUBContent(db *DB, libraryPath string, bookID int, query string, lim
 	return matches, nil
 }
 
+// readPackage locates and parses the OPF package document of an EPUB,
+// returning it along with its path inside the archive
+func readPackage(r *zip.ReadCloser) (*Package, string, error) {
+	// Read container.xml
+	containerFile, err := r.Open("META-INF/container.xml")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open container.xml: %w", err)
+	}
+	defer containerFile.Close()
+
+	var container Container
+	if err := xml.NewDecoder(containerFile).Decode(&container); err != nil {
+		return nil, "", fmt.Errorf("failed to parse container.xml: %w", err)
+	}
+
+	if len(container.Rootfiles) == 0 {
+		return nil, "", fmt.Errorf("no rootfile found")
+	}
+
+	opfPath := container.Rootfiles[0].Path
+
+	// Read content.opf
+	data, err := readOPF(r, opfPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var pkg Package
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return nil, "", fmt.Errorf("failed to parse OPF: %w", err)
+	}
+
+	return &pkg, opfPath, nil
+}
+
+func readOPF(r *zip.ReadCloser, opfPath string) ([]byte, error) {
+	opfFile, err := r.Open(opfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OPF: %w", err)
+	}
+	defer opfFile.Close()
+
+	data, err := io.ReadAll(opfFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OPF: %w", err)
+	}
+	return data, nil
+}
+
+// coverItem returns the manifest item declared as the cover image, either
+// through the EPUB 3 cover-image property or the EPUB 2 cover meta element
+func (pkg *Package) coverItem() (Item, bool) {
+	for _, item := range pkg.Manifest.Items {
+		if slices.Contains(strings.Fields(item.Properties), "cover-image") {
+			return item, true
+		}
+	}
+	for _, meta := range pkg.Metadata.Metas {
+		if meta.Name != "cover" {
+			continue
+		}
+		for _, item := range pkg.Manifest.Items {
+			if item.Id == meta.Content {
+				return item, true
+			}
+		}
+	}
+	return Item{}, false
+}
+
+// resolveHref turns a manifest href, which is relative to the OPF document,
+// into a path inside the EPUB archive
+func resolveHref(opfPath string, href string) string {
+	if i := strings.IndexByte(href, '#'); i != -1 {
+		href = href[:i]
+	}
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Join(path.Dir(opfPath), href)
+}
+
 func getEPUBPath(db *DB, libraryPath string, bookID int) (string, error) {
+	return getFormatPath(db, libraryPath, bookID, "EPUB")
+}
+
+// getFormatPath returns the location on disk of the given format of a book
+func getFormatPath(db *DB, libraryPath string, bookID int, format string) (string, error) {
 	var path, filename string
 	err := db.QueryRow(`
 		SELECT b.path, d.name
 		FROM books b
 		JOIN data d ON b.id = d.book
-		WHERE b.id = ? AND d.format = 'EPUB'
+		WHERE b.id = ? AND d.format = ?
 		LIMIT 1
-	`, bookID).Scan(&path, &filename)
+	`, bookID, format).Scan(&path, &filename)
 	if err != nil {
-		return "", fmt.Errorf("EPUB not found for book %d: %w", bookID, err)
+		return "", fmt.Errorf("%s not found for book %d: %w", format, bookID, err)
 	}
 
-	return filepath.Join(libraryPath, path, filename+".epub"), nil
+	return filepath.Join(libraryPath, path, filename+"."+strings.ToLower(format)), nil
 }
 
 func extractTitleFromHTML(html string) string {
@@ -316,6 +442,8 @@ func extractTextFromHTML(html string) string {
 	text = strings.ReplaceAll(text, "</h5>", "\n")
 	text = strings.ReplaceAll(text, "<h6>", "")
 	text = strings.ReplaceAll(text, "</h6>", "\n")
+	text = strings.ReplaceAll(text, "</figcaption>", "\n")
+	text = strings.ReplaceAll(text, "</figure>", "\n")
 	// Remove other tags
 	for strings.Contains(text, "<") && strings.Contains(text, ">") {
 		start := strings.Index(text, "<")
@@ -0,0 +1,269 @@
+package calibre
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ChapterImage is an image or figure referenced from a chapter
+type ChapterImage struct {
+	Index     int    `json:"index"`
+	Src       string `json:"src"`
+	Alt       string `json:"alt"`
+	Caption   string `json:"caption"`
+	MediaType string `json:"media_type"`
+
+	// start is the byte offset of the element in the chapter markup
+	start int
+}
+
+// Label returns the number of the image with its caption or alt text
+func (img ChapterImage) Label() string {
+	text := img.Caption
+	if text == "" {
+		text = img.Alt
+	}
+	if text == "" {
+		return fmt.Sprintf("[Figure %d]", img.Index+1)
+	}
+	return fmt.Sprintf("[Figure %d: %s]", img.Index+1, text)
+}
+
+// placeholder returns the label left in the chapter text in place of the
+// image. The caption is left out, as the text of the figcaption follows.
+func (img ChapterImage) placeholder() string {
+	if img.Caption == "" && img.Alt != "" {
+		return fmt.Sprintf("[Figure %d: %s]", img.Index+1, img.Alt)
+	}
+	return fmt.Sprintf("[Figure %d]", img.Index+1)
+}
+
+// GetEPUBChapterImages lists the images of a chapter in document order, with
+// their alt text, figure caption and manifest media type
+func GetEPUBChapterImages(db *DB, libraryPath string, bookID int, chapterIndex int) ([]ChapterImage, error) {
+	epubPath, err := getEPUBPath(db, libraryPath, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	defer r.Close()
+
+	images, _, err := readChapterImages(db, libraryPath, bookID, r, chapterIndex)
+	return images, err
+}
+
+// GetEPUBImage returns an image of a chapter, downscaled so that neither side
+// exceeds maxDimension when it is greater than zero
+func GetEPUBImage(db *DB, libraryPath string, bookID int, chapterIndex int, imageIndex int, maxDimension int) (*Image, error) {
+	epubPath, err := getEPUBPath(db, libraryPath, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	defer r.Close()
+
+	images, _, err := readChapterImages(db, libraryPath, bookID, r, chapterIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	if imageIndex < 0 || imageIndex >= len(images) {
+		return nil, fmt.Errorf("image index out of range")
+	}
+
+	img := images[imageIndex]
+	if img.Src == "" {
+		return nil, fmt.Errorf("image %d is an inline SVG drawing and has no image file", imageIndex)
+	}
+
+	f, err := r.Open(img.Src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	return newImage(data, maxDimension)
+}
+
+// readChapterImages parses the images of a chapter from an open EPUB,
+// returning them along with the chapter markup
+func readChapterImages(db *DB, libraryPath string, bookID int, r *zip.ReadCloser, chapterIndex int) ([]ChapterImage, string, error) {
+	chapters, err := GetEPUBChapters(db, libraryPath, bookID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if chapterIndex < 0 || chapterIndex >= len(chapters) {
+		return nil, "", fmt.Errorf("chapter index out of range")
+	}
+
+	pkg, opfPath, err := readPackage(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	chapterPath, data, err := readChapterFile(r, opfPath, chapters[chapterIndex].Href)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Map archive paths to manifest media types
+	mediaTypes := make(map[string]string)
+	for _, item := range pkg.Manifest.Items {
+		mediaTypes[resolveHref(opfPath, item.Href)] = item.MediaType
+	}
+
+	images := parseChapterImages(string(data))
+	for i := range images {
+		if images[i].Src == "" {
+			images[i].MediaType = "image/svg+xml"
+			continue
+		}
+		images[i].Src = resolveHref(chapterPath, images[i].Src)
+		images[i].MediaType = mediaTypes[images[i].Src]
+		if images[i].MediaType == "" {
+			images[i].MediaType = imageMediaType(images[i].Src, nil)
+		}
+	}
+
+	return images, string(data), nil
+}
+
+// parseChapterImages finds the <img> and <svg> elements of a chapter in
+// document order, along with their offsets in the markup. Sources are left
+// relative to the chapter file.
+func parseChapterImages(html string) []ChapterImage {
+	decoder := xml.NewDecoder(strings.NewReader(html))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	images := make([]ChapterImage, 0)
+	// The open <figure> elements, with the index of their first image and
+	// their caption, which is given to their images once closed since it may
+	// come before them
+	type figure struct {
+		start   int
+		caption string
+	}
+	var figures []figure
+	var caption *strings.Builder
+	svgDepth := 0
+
+	for {
+		offset := int(decoder.InputOffset())
+		tok, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "figure":
+				figures = append(figures, figure{start: len(images)})
+			case "figcaption":
+				caption = &strings.Builder{}
+			case "img":
+				if svgDepth == 0 {
+					images = append(images, ChapterImage{
+						Index: len(images),
+						Src:   attr(t, "src"),
+						Alt:   attr(t, "alt"),
+						start: offset,
+					})
+				}
+			case "svg":
+				if svgDepth == 0 {
+					images = append(images, ChapterImage{Index: len(images), start: offset})
+				}
+				svgDepth++
+			case "image":
+				// An SVG wrapper around a raster image, as used for covers
+				if svgDepth > 0 && images[len(images)-1].Src == "" {
+					images[len(images)-1].Src = attr(t, "href")
+				}
+			}
+		case xml.EndElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "figure":
+				if len(figures) > 0 {
+					f := figures[len(figures)-1]
+					figures = figures[:len(figures)-1]
+					// Images of a nested figure keep its own caption
+					for i := f.start; i < len(images); i++ {
+						if images[i].Caption == "" {
+							images[i].Caption = f.caption
+						}
+					}
+				}
+			case "figcaption":
+				if caption != nil && len(figures) > 0 {
+					figures[len(figures)-1].caption = strings.Join(strings.Fields(caption.String()), " ")
+				}
+				caption = nil
+			case "svg":
+				svgDepth--
+			}
+		case xml.CharData:
+			if caption != nil {
+				caption.Write(t)
+			}
+		}
+	}
+
+	return images
+}
+
+// attr returns the value of the attribute with the given local name
+func attr(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if strings.EqualFold(a.Name.Local, name) {
+			return strings.TrimSpace(a.Value)
+		}
+	}
+	return ""
+}
+
+// readChapterFile reads a spine document, resolving its manifest href against
+// the OPF location and returning its path inside the archive
+func readChapterFile(r *zip.ReadCloser, opfPath string, href string) (string, []byte, error) {
+	chapterPath := resolveHref(opfPath, href)
+	f, err := r.Open(chapterPath)
+	if err != nil {
+		// Some EPUBs use hrefs relative to the archive root
+		chapterPath = strings.TrimPrefix(href, "/")
+		if unescaped, err := url.PathUnescape(chapterPath); err == nil {
+			chapterPath = unescaped
+		}
+		f, err = r.Open(chapterPath)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to open chapter: %w", err)
+		}
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read chapter: %w", err)
+	}
+
+	return path.Clean(chapterPath), data, nil
+}
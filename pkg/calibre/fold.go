@@ -0,0 +1,26 @@
+package calibre
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// foldReplacer expands the letters that don't decompose into a base letter
+// and a combining mark
+var foldReplacer = strings.NewReplacer(
+	"æ", "ae", "œ", "oe", "ß", "ss", "ø", "o", "ł", "l", "đ", "d", "ð", "d", "þ", "th", "ı", "i",
+)
+
+// fold lowercases a string and strips its accents, so that "Émile" matches
+// "emile" the way Calibre's searches and sorting ignore case and accents
+func fold(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return foldReplacer.Replace(b.String())
+}
@@ -0,0 +1,53 @@
+package calibre
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// formatMediaTypes maps Calibre format names to their media types
+var formatMediaTypes = map[string]string{
+	"AZW":   "application/vnd.amazon.ebook",
+	"AZW3":  "application/vnd.amazon.ebook",
+	"CB7":   "application/x-cb7",
+	"CBR":   "application/vnd.comicbook-rar",
+	"CBZ":   "application/vnd.comicbook+zip",
+	"DJVU":  "image/vnd.djvu",
+	"DOCX":  "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"EPUB":  "application/epub+zip",
+	"FB2":   "application/x-fictionbook+xml",
+	"HTML":  "text/html",
+	"HTMLZ": "application/zip",
+	"KEPUB": "application/epub+zip",
+	"LIT":   "application/x-ms-reader",
+	"MOBI":  "application/x-mobipocket-ebook",
+	"ODT":   "application/vnd.oasis.opendocument.text",
+	"PDF":   "application/pdf",
+	"RTF":   "application/rtf",
+	"TXT":   "text/plain",
+	"TXTZ":  "application/zip",
+	"ZIP":   "application/zip",
+}
+
+// FormatMediaType returns the media type of a Calibre format such as EPUB
+func FormatMediaType(format string) string {
+	if mediaType, ok := formatMediaTypes[strings.ToUpper(format)]; ok {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// ReadBookFormat returns the raw contents of the given format of a book
+func ReadBookFormat(db *DB, libraryPath string, bookID int, format string) ([]byte, error) {
+	formatPath, err := getFormatPath(db, libraryPath, bookID, strings.ToUpper(format))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(formatPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", strings.ToUpper(format), err)
+	}
+	return data, nil
+}
@@ -0,0 +1,300 @@
+package calibre
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChangeSet is a set of book changes committed together. ID numbers the
+// journal entry that recorded it, and is zero for changes that were not
+// committed or journaled. RevertOf is the ID of the change set it reverted,
+// Added lists the books it added to the library, and Embedded the books whose
+// EPUB it wrote the metadata into.
+type ChangeSet struct {
+	ID       int          `json:"id,omitempty"`
+	Time     string       `json:"time,omitempty"`
+	RevertOf int          `json:"revert_of,omitempty"`
+	Added    []int        `json:"added,omitempty"`
+	Embedded []int        `json:"embedded,omitempty"`
+	Changes  []BookChange `json:"changes"`
+}
+
+// Journal is an append-only log of the changes made to the library, kept as
+// JSON lines with the old and new value of each changed field so that
+// changes can be reverted. It also backs up metadata.db before the first
+// change made after it is opened.
+type Journal struct {
+	path            string
+	backupDir       string
+	backupRetention int
+
+	mu       sync.Mutex
+	lastID   int
+	backedUp bool
+}
+
+// OpenJournal opens the journal kept in dir, where metadata.db backups are
+// stored too, in a backups folder. Only the last backupRetention backups are
+// kept, or all of them when it is zero.
+func OpenJournal(dir string, backupRetention int) (*Journal, error) {
+	j := &Journal{
+		path:            filepath.Join(dir, "journal.jsonl"),
+		backupDir:       filepath.Join(dir, "backups"),
+		backupRetention: backupRetention,
+	}
+	if err := os.MkdirAll(j.backupDir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		j.lastID = max(j.lastID, entry.ID)
+	}
+	return j, nil
+}
+
+// Entries returns the change sets of the journal, oldest first
+func (j *Journal) Entries() ([]ChangeSet, error) {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []ChangeSet{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []ChangeSet{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry ChangeSet
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("journal entry after change %d: %w", len(entries), err)
+		}
+		// Lists are decoded as []any, while fields hold []string
+		for i := range entry.Changes {
+			for k := range entry.Changes[i].Changes {
+				change := &entry.Changes[i].Changes[k]
+				change.Old, change.New = journalValue(change.Old), journalValue(change.New)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func journalValue(value any) any {
+	list, ok := value.([]any)
+	if !ok {
+		return value
+	}
+	values := make([]string, len(list))
+	for i, item := range list {
+		values[i] = fmt.Sprint(item)
+	}
+	return values
+}
+
+// Entry returns the change set with the given ID
+func (j *Journal) Entry(id int) (*ChangeSet, error) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return &entry, nil
+		}
+	}
+	return nil, fmt.Errorf("change %d not found in the journal", id)
+}
+
+// prepare backs up metadata.db before the first write made with the journal
+func (j *Journal) prepare(ctx context.Context, db *DB) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.backedUp {
+		return nil
+	}
+
+	name := fmt.Sprintf("metadata-%s.db", time.Now().UTC().Format("20060102-150405"))
+	if err := db.Backup(ctx, filepath.Join(j.backupDir, name)); err != nil {
+		return fmt.Errorf("backing up metadata.db: %w", err)
+	}
+	j.backedUp = true
+
+	// Drop the oldest backups, which sort first
+	if j.backupRetention <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(filepath.Join(j.backupDir, "metadata-*.db"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > j.backupRetention {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// JournalError is returned when a transaction was committed but its change
+// set could not be recorded, so the changes were made but can't be undone
+type JournalError struct {
+	Err error
+}
+
+func (e *JournalError) Error() string {
+	return "the changes were made but not journaled: " + e.Err.Error()
+}
+
+func (e *JournalError) Unwrap() error {
+	return e.Err
+}
+
+// commit commits the transaction of a change set, then records the change
+// set, giving it an ID and a time, and syncs the journal to disk. Recording it
+// only once committed keeps changes that failed to commit out of the journal,
+// and holding the lock meanwhile keeps the journal in commit order. Failing
+// to record it returns a *JournalError.
+func (j *Journal) commit(tx *sql.Tx, set *ChangeSet) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if err := j.append(set); err != nil {
+		set.ID, set.Time = 0, ""
+		return &JournalError{Err: err}
+	}
+	return nil
+}
+
+// append records a change set, with the lock held
+func (j *Journal) append(set *ChangeSet) error {
+	set.ID = j.lastID + 1
+	set.Time = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	j.lastID = set.ID
+	return nil
+}
+
+// RevertChange restores the old values of the fields changed by a journaled
+// change set, recording the revert as a new change set. It fails without
+// writing anything if the books changed since, or the change set was already
+// reverted. Reverting a revert makes the change again.
+func RevertChange(ctx context.Context, db *DB, id int) (*ChangeSet, error) {
+	if db.journal == nil {
+		return nil, fmt.Errorf("no journal of changes")
+	}
+	entries, err := db.journal.Entries()
+	if err != nil {
+		return nil, err
+	}
+	var target *ChangeSet
+	for i, entry := range entries {
+		if entry.RevertOf == id {
+			return nil, fmt.Errorf("change %d was already reverted by change %d", id, entry.ID)
+		}
+		if entry.ID == id {
+			target = &entries[i]
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("change %d not found in the journal", id)
+	}
+	if len(target.Added) > 0 {
+		return nil, fmt.Errorf("change %d added books, which can't be reverted, remove them with Calibre instead", id)
+	}
+	if len(target.Embedded) > 0 {
+		return nil, fmt.Errorf("change %d rewrote EPUB files, which can't be reverted, restore their ORIGINAL_EPUB format with Calibre instead", id)
+	}
+
+	// Give back the old values, expecting to change each field from its new
+	// value to its old one
+	plan := &EditPlan{Edits: []BookEdit{}, Changes: []BookChange{}}
+	for _, book := range target.Changes {
+		edit := BookEdit{BookID: book.BookID}
+		expected := BookChange{BookID: book.BookID, Title: book.Title, Changes: []FieldChange{}}
+		for _, change := range book.Changes {
+			edit.Fields = append(edit.Fields, FieldValue{Field: change.Field, Value: change.Old})
+			expected.Changes = append(expected.Changes, FieldChange{Field: change.Field, Old: change.New, New: change.Old})
+			if change.Field == FieldTitle {
+				expected.Title, _ = change.New.(string)
+			}
+		}
+		plan.Edits = append(plan.Edits, edit)
+		plan.Changes = append(plan.Changes, expected)
+	}
+
+	set, err := editBooks(ctx, db, plan.Edits, editOptions{commit: true, revertOf: id, verify: plan.verify})
+	if errors.Is(err, ErrPlanOutdated) {
+		return nil, fmt.Errorf("the books changed since change %d, it can't be reverted", id)
+	}
+	return set, err
+}
+
+// UndoLastChange reverts the most recent journaled change set that is not a
+// revert and was not reverted yet. Change sets that added books or rewrote
+// EPUB files can't be reverted, so they are passed over.
+func UndoLastChange(ctx context.Context, db *DB) (*ChangeSet, error) {
+	if db.journal == nil {
+		return nil, fmt.Errorf("no journal of changes")
+	}
+	entries, err := db.journal.Entries()
+	if err != nil {
+		return nil, err
+	}
+	reverted := make(map[int]bool)
+	for _, entry := range entries {
+		if entry.RevertOf != 0 {
+			reverted[entry.ID] = true
+			reverted[entry.RevertOf] = true
+		}
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !reverted[entries[i].ID] && len(entries[i].Added) == 0 && len(entries[i].Embedded) == 0 {
+			return RevertChange(ctx, db, entries[i].ID)
+		}
+	}
+	return nil, fmt.Errorf("no change to undo")
+}
@@ -0,0 +1,331 @@
+package calibre
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// uuidPattern matches the UUIDs Calibre gives books
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// BookCandidate is a book matching a title or author lookup, with enough
+// details to tell editions apart
+type BookCandidate struct {
+	ID      int      `json:"id"`
+	Title   string   `json:"title"`
+	Authors []string `json:"authors"`
+	Year    string   `json:"year"`
+	Formats []string `json:"formats"`
+}
+
+// FindBooks returns the books whose title and authors match, ignoring case
+// and accents. Exact title matches are preferred; when there are none, titles
+// containing the given title are returned. Either title or author may be empty.
+func FindBooks(ctx context.Context, db *DB, title string, author string) ([]BookCandidate, error) {
+	query := `
+		SELECT id, title, COALESCE(strftime('%Y', pubdate), '')
+		FROM books`
+	var args []any
+	author = fold(strings.TrimSpace(author))
+	if author != "" {
+		// Narrow the books down to the matching authors first, rather than
+		// loading the authors of every book of the library
+		authorIDs, err := findAuthors(ctx, db, author)
+		if err != nil {
+			return nil, err
+		}
+		if len(authorIDs) == 0 {
+			return []BookCandidate{}, nil
+		}
+		query += `
+		WHERE id IN (SELECT book FROM books_authors_link WHERE author IN (` +
+			strings.TrimSuffix(strings.Repeat("?, ", len(authorIDs)), ", ") + `))`
+		for _, id := range authorIDs {
+			args = append(args, id)
+		}
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	title = fold(strings.TrimSpace(title))
+	var exact, partial []BookCandidate
+	for rows.Next() {
+		var c BookCandidate
+		if err := rows.Scan(&c.ID, &c.Title, &c.Year); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		folded := fold(c.Title)
+		switch {
+		case title == "" || folded == title:
+			exact = append(exact, c)
+		case strings.Contains(folded, title):
+			partial = append(partial, c)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var candidates []BookCandidate
+	for _, group := range [][]BookCandidate{exact, partial} {
+		for _, c := range group {
+			c.Authors, err = getAuthorsForBook(db, c.ID)
+			if err != nil {
+				return nil, err
+			}
+			c.Formats, err = getFormatsForBook(db, c.ID)
+			if err != nil {
+				return nil, err
+			}
+			// Calibre stores an undefined publication date as year 101
+			if c.Year == "0101" {
+				c.Year = ""
+			}
+			candidates = append(candidates, c)
+		}
+		if len(candidates) > 0 {
+			break
+		}
+	}
+	if candidates == nil {
+		candidates = []BookCandidate{}
+	}
+	return candidates, nil
+}
+
+// getBookCandidate returns a book with the details of a lookup candidate
+func getBookCandidate(ctx context.Context, db *DB, id int) (*BookCandidate, error) {
+	c := BookCandidate{ID: id}
+	err := db.QueryRowContext(ctx, `
+		SELECT title, COALESCE(strftime('%Y', pubdate), '')
+		FROM books
+		WHERE id = ?
+	`, id).Scan(&c.Title, &c.Year)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("book not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Year == "0101" {
+		c.Year = ""
+	}
+	if c.Authors, err = getAuthorsForBook(db, id); err != nil {
+		return nil, err
+	}
+	if c.Formats, err = getFormatsForBook(db, id); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// findAuthors returns the IDs of the authors whose name contains the folded
+// author name
+func findAuthors(ctx context.Context, db *DB, author string) ([]int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, name FROM authors")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		if strings.Contains(fold(name), author) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// GetBookIDByUUID returns the ID of the book with the given UUID, which, unlike
+// the ID, is the same in every copy of a library
+func GetBookIDByUUID(ctx context.Context, db *DB, uuid string) (int, error) {
+	var id int
+	err := db.QueryRowContext(ctx, `
+		SELECT id
+		FROM books
+		WHERE uuid = ? COLLATE NOCASE
+	`, strings.TrimSpace(uuid)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no book with UUID %q", uuid)
+	}
+	return id, err
+}
+
+// FindBooksByIdentifier returns the IDs of the books matching an identifier
+// written as type:value, such as isbn:9780441013593, goodreads:234225 or
+// uuid:<uuid>. A bare value is taken as a UUID or an ISBN from its form. ISBNs
+// match whether they are stored as ISBN-10 or ISBN-13, with or without hyphens.
+func FindBooksByIdentifier(ctx context.Context, db *DB, identifier string) ([]int, error) {
+	identifier = strings.TrimSpace(identifier)
+	kind, value, ok := strings.Cut(identifier, ":")
+	if !ok {
+		value = identifier
+		switch {
+		case uuidPattern.MatchString(value):
+			kind = "uuid"
+		case normalizeISBN(value) != "":
+			kind = "isbn"
+		default:
+			return nil, fmt.Errorf("identifier %q must be written as type:value", identifier)
+		}
+	}
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, fmt.Errorf("identifier %q has no value", identifier)
+	}
+
+	switch kind {
+	case "uuid":
+		id, err := GetBookIDByUUID(ctx, db, value)
+		if err != nil {
+			return nil, err
+		}
+		return []int{id}, nil
+	case "isbn", "isbn10", "isbn13":
+		return findBooksByISBN(ctx, db, value)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT book
+		FROM identifiers
+		WHERE type = ? COLLATE NOCASE AND val = ? COLLATE NOCASE
+		ORDER BY book
+	`, kind, value)
+	if err != nil {
+		return nil, err
+	}
+	return scanIDs(rows)
+}
+
+// findBooksByISBN compares ISBNs in their ISBN-13 form, looking at both the
+// isbn identifiers and the legacy books.isbn column
+func findBooksByISBN(ctx context.Context, db *DB, isbn string) ([]int, error) {
+	want := normalizeISBN(isbn)
+	if want == "" {
+		return nil, fmt.Errorf("%q is not a valid ISBN", isbn)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT book, val
+		FROM identifiers
+		WHERE type IN ('isbn', 'isbn10', 'isbn13')
+		UNION
+		SELECT id, isbn
+		FROM books
+		WHERE isbn IS NOT NULL AND isbn != ''
+		ORDER BY 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		var val string
+		if err := rows.Scan(&id, &val); err != nil {
+			return nil, err
+		}
+		if normalizeISBN(val) == want && (len(ids) == 0 || ids[len(ids)-1] != id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// FindBooksByTitle returns the IDs of the books with exactly the given title
+// and, if author is not empty, exactly that author, ignoring case and accents
+func FindBooksByTitle(ctx context.Context, db *DB, title string, author string) ([]int, error) {
+	candidates, err := FindBooks(ctx, db, title, "")
+	if err != nil {
+		return nil, err
+	}
+
+	title = fold(strings.TrimSpace(title))
+	author = fold(strings.TrimSpace(author))
+	ids := []int{}
+	for _, c := range candidates {
+		if fold(c.Title) != title {
+			continue
+		}
+		if author != "" && !hasAuthor(c.Authors, author) {
+			continue
+		}
+		ids = append(ids, c.ID)
+	}
+	return ids, nil
+}
+
+func hasAuthor(authors []string, author string) bool {
+	for _, a := range authors {
+		if fold(a) == author {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeISBN returns the ISBN-13 form of an ISBN-10 or ISBN-13, ignoring
+// hyphens, spaces and an "urn:isbn:" prefix, or an empty string if s is not
+// an ISBN
+func normalizeISBN(s string) string {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "urn:isbn:")
+	var digits []byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case c == 'x' && len(digits) == 9 && i == len(s)-1:
+			digits = append(digits, 'X')
+		case c == '-' || c == ' ':
+		default:
+			return ""
+		}
+	}
+
+	switch len(digits) {
+	case 13:
+		return string(digits)
+	case 10:
+		// Prefix the first nine digits with 978 and compute the new check digit
+		isbn := append([]byte("978"), digits[:9]...)
+		sum := 0
+		for i, c := range isbn {
+			weight := 1
+			if i%2 == 1 {
+				weight = 3
+			}
+			sum += int(c-'0') * weight
+		}
+		return string(append(isbn, byte('0'+(10-sum%10)%10)))
+	}
+	return ""
+}
+
+func scanIDs(rows *sql.Rows) ([]int, error) {
+	defer rows.Close()
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
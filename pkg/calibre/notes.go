@@ -0,0 +1,257 @@
+package calibre
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// noteCategories are the categories Calibre keeps notes for, with the field
+// name notes.db uses for each, the table of its items and the query of the
+// items of a book
+var noteCategories = map[string]struct {
+	field string
+	table string
+	link  string
+}{
+	"authors":    {"authors", "authors", "SELECT author FROM books_authors_link WHERE book = ? ORDER BY id"},
+	"tags":       {"tags", "tags", "SELECT tag FROM books_tags_link WHERE book = ?"},
+	"series":     {"series", "series", "SELECT series FROM books_series_link WHERE book = ?"},
+	"publishers": {"publisher", "publishers", "SELECT publisher FROM books_publishers_link WHERE book = ?"},
+}
+
+// Note is a note Calibre 7 keeps on an author, tag, series or publisher in
+// .calnotes/notes.db. Text is the note as plain text, and Resources the names
+// of the images and files it links to.
+type Note struct {
+	Category  string   `json:"category"`
+	Name      string   `json:"name"`
+	Text      string   `json:"text"`
+	Resources []string `json:"resources,omitempty"`
+	Modified  string   `json:"modified"`
+}
+
+type NoteSearchResult struct {
+	Notes    []Note `json:"notes"`
+	TotalNum int    `json:"total_num"`
+}
+
+// openNotes opens the notes database of a library read-only, returning nil
+// when the library has no notes
+func openNotes(libraryPath string) (*sql.DB, error) {
+	notesPath := filepath.Join(libraryPath, ".calnotes", "notes.db")
+	if _, err := os.Stat(notesPath); err != nil {
+		return nil, nil
+	}
+	// Escape the characters that have a meaning in SQLite URIs
+	uri := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(filepath.ToSlash(notesPath))
+	return sql.Open(driverName, "file:"+uri+"?mode=ro&_busy_timeout=5000")
+}
+
+// GetNote returns the note on an item of a category, or nil when it has none
+func GetNote(ctx context.Context, db *DB, category string, name string) (*Note, error) {
+	c, ok := noteCategories[category]
+	if !ok || db.notes == nil {
+		return nil, nil
+	}
+	var id int
+	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT id FROM %s WHERE name = ? COLLATE NOCASE", c.table), strings.TrimSpace(name)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	notes, err := readNotes(ctx, db, category, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if note, ok := notes[id]; ok {
+		return &note, nil
+	}
+	return nil, nil
+}
+
+// getBookNotes returns the notes on the authors, tags, series and publisher
+// of a book
+func getBookNotes(ctx context.Context, db *DB, bookID int) ([]Note, error) {
+	notes := make([]Note, 0)
+	if db.notes == nil {
+		return notes, nil
+	}
+	for _, category := range []string{"authors", "series", "publishers", "tags"} {
+		rows, err := db.QueryContext(ctx, noteCategories[category].link, bookID)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := scanIDs(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID, err := readNotes(ctx, db, category, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if note, ok := byID[id]; ok {
+				notes = append(notes, note)
+			}
+		}
+	}
+	return notes, nil
+}
+
+// readNotes returns the notes on items of a category, keyed by item ID
+func readNotes(ctx context.Context, db *DB, category string, ids []int) (map[int]Note, error) {
+	notes := make(map[int]Note)
+	c, ok := noteCategories[category]
+	if !ok || db.notes == nil || len(ids) == 0 {
+		return notes, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := []any{c.field}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := db.notes.QueryContext(ctx, `
+		SELECT id, item, doc, searchable_text, mtime
+		FROM notes
+		WHERE colname = ? AND item IN (`+placeholders+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("notes: %w", err)
+	}
+	noteIDs := make(map[int]int)
+	for rows.Next() {
+		var noteID, item int
+		var doc, searchable string
+		var mtime sql.NullFloat64
+		if err := rows.Scan(&noteID, &item, &doc, &searchable, &mtime); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("notes: %w", err)
+		}
+		note := Note{Category: category, Text: searchable}
+		if searchable == "" {
+			note.Text = extractTextFromHTML(doc)
+		}
+		if mtime.Valid {
+			note.Modified = time.Unix(int64(mtime.Float64), 0).UTC().Format(time.RFC3339)
+		}
+		notes[item] = note
+		noteIDs[item] = noteID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("notes: %w", err)
+	}
+
+	for item, note := range notes {
+		// Notes of items removed from the library are kept in notes.db
+		err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT name FROM %s WHERE id = ?", c.table), item).Scan(&note.Name)
+		if err == sql.ErrNoRows {
+			delete(notes, item)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Calibre indexes the name of the item before the note
+		note.Text = strings.TrimSpace(strings.TrimPrefix(note.Text, note.Name+"\n"))
+		if note.Resources, err = readNoteResources(ctx, db, noteIDs[item]); err != nil {
+			return nil, err
+		}
+		notes[item] = note
+	}
+	return notes, nil
+}
+
+// readNoteResources returns the names of the resources linked to a note
+func readNoteResources(ctx context.Context, db *DB, noteID int) ([]string, error) {
+	rows, err := db.notes.QueryContext(ctx, `
+		SELECT r.name
+		FROM notes_resources_link l
+		JOIN resources r ON l.resource = r.hash
+		WHERE l.note = ?
+		ORDER BY r.name
+	`, noteID)
+	if err != nil {
+		return nil, fmt.Errorf("notes: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("notes: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// SearchNotes returns the notes on authors, tags, series and publishers that
+// contain every word of the query, ignoring case and accents, most recently
+// modified first. Calibre's notes_fts tables need its own tokenizer, so the
+// words are matched against the searchable_text they index instead.
+func SearchNotes(ctx context.Context, db *DB, query string, limit int, offset int) (*NoteSearchResult, error) {
+	result := &NoteSearchResult{Notes: []Note{}}
+	if db.notes == nil {
+		return result, nil
+	}
+	rows, err := db.notes.QueryContext(ctx, "SELECT item, colname, searchable_text FROM notes")
+	if err != nil {
+		return nil, fmt.Errorf("notes: %w", err)
+	}
+	words := strings.Fields(fold(query))
+	matches := make(map[string][]int)
+	for rows.Next() {
+		var item int
+		var field, searchable string
+		if err := rows.Scan(&item, &field, &searchable); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("notes: %w", err)
+		}
+		text := fold(searchable)
+		matched := true
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches[field] = append(matches[field], item)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("notes: %w", err)
+	}
+
+	var notes []Note
+	for category, c := range noteCategories {
+		byID, err := readNotes(ctx, db, category, matches[c.field])
+		if err != nil {
+			return nil, err
+		}
+		for _, note := range byID {
+			notes = append(notes, note)
+		}
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		if notes[i].Modified != notes[j].Modified {
+			return notes[i].Modified > notes[j].Modified
+		}
+		return notes[i].Name < notes[j].Name
+	})
+
+	result.TotalNum = len(notes)
+	result.Notes = append(result.Notes, paginate(notes, limit, offset)...)
+	return result, nil
+}
@@ -0,0 +1,167 @@
+package calibre
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The metadata of an OPF document is read as Calibre reads it, from the
+// Dublin Core elements and the meta elements of both EPUB 2 and EPUB 3.
+
+// named returns the content of the EPUB 2 meta element with the given name,
+// such as calibre:series
+func (md *Metadata) named(name string) string {
+	for _, meta := range md.Metas {
+		if meta.Name == name {
+			return strings.TrimSpace(meta.Content)
+		}
+	}
+	return ""
+}
+
+// refinement returns the value of the EPUB 3 meta element that refines the
+// element with the given ID with a property, such as file-as
+func (md *Metadata) refinement(id string, property string) string {
+	if id == "" {
+		return ""
+	}
+	for _, meta := range md.Metas {
+		if meta.Refines == "#"+id && meta.Property == property {
+			return strings.TrimSpace(meta.Value)
+		}
+	}
+	return ""
+}
+
+// title returns the main title, which EPUB 3 tells apart from subtitles
+func (md *Metadata) title() string {
+	for _, title := range md.Titles {
+		if md.refinement(title.ID, "title-type") == "main" {
+			return strings.TrimSpace(title.Value)
+		}
+	}
+	if len(md.Titles) > 0 {
+		return strings.TrimSpace(md.Titles[0].Value)
+	}
+	return ""
+}
+
+// authors returns the creators that are authors, those with the aut role or
+// none, with the role and sort name of EPUB 3 refinements
+func (md *Metadata) authors() []Creator {
+	var authors []Creator
+	for _, creator := range md.Creators {
+		if creator.Role == "" {
+			creator.Role = md.refinement(creator.ID, "role")
+		}
+		if creator.FileAs == "" {
+			creator.FileAs = md.refinement(creator.ID, "file-as")
+		}
+		creator.Name = strings.TrimSpace(creator.Name)
+		role := strings.ToLower(strings.TrimSpace(creator.Role))
+		if creator.Name != "" && (role == "" || role == "aut") {
+			authors = append(authors, creator)
+		}
+	}
+	return authors
+}
+
+// subjects returns the subjects, which Calibre reads as tags
+func (md *Metadata) subjects() []string {
+	var subjects []string
+	for _, subject := range md.Subjects {
+		// Some files list several subjects in one element
+		for _, s := range strings.Split(subject.Value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				subjects = append(subjects, s)
+			}
+		}
+	}
+	return subjects
+}
+
+// series returns the series and the index in it, from Calibre's meta elements
+// or else an EPUB 3 collection
+func (md *Metadata) series() (string, float64) {
+	if series := md.named("calibre:series"); series != "" {
+		index, err := strconv.ParseFloat(md.named("calibre:series_index"), 64)
+		if err != nil {
+			index = 1
+		}
+		return series, index
+	}
+	for _, meta := range md.Metas {
+		if meta.Property != "belongs-to-collection" || meta.Refines != "" || strings.TrimSpace(meta.Value) == "" {
+			continue
+		}
+		if typ := md.refinement(meta.ID, "collection-type"); typ != "" && typ != "series" {
+			continue
+		}
+		index, err := strconv.ParseFloat(md.refinement(meta.ID, "group-position"), 64)
+		if err != nil {
+			index = 1
+		}
+		return strings.TrimSpace(meta.Value), index
+	}
+	return "", 0
+}
+
+// publisher returns the first publisher
+func (md *Metadata) publisher() string {
+	if len(md.Publishers) > 0 {
+		return strings.TrimSpace(md.Publishers[0].Value)
+	}
+	return ""
+}
+
+// pubDate returns the publication date, as YYYY-MM-DD or a shorter prefix
+// when the file only gives the year or month. EPUB 2 dates without an event
+// are taken as publication dates.
+func (md *Metadata) pubDate() string {
+	for _, date := range md.Dates {
+		event := strings.ToLower(strings.TrimSpace(date.Event))
+		if event != "" && event != "publication" {
+			continue
+		}
+		value, _, _ := strings.Cut(strings.TrimSpace(date.Value), "T")
+		if strings.HasPrefix(value, "0101-") {
+			// Calibre's undefined date
+			return ""
+		}
+		return value
+	}
+	return ""
+}
+
+// languages returns the codes Calibre stores for the languages, such as eng
+func (md *Metadata) languages() []string {
+	var languages []string
+	for _, lang := range md.Languages {
+		if code := languageCode(lang); code != "" {
+			languages = append(languages, code)
+		}
+	}
+	return languages
+}
+
+// identifiers returns the identifiers by the type Calibre stores them with,
+// such as isbn
+func (md *Metadata) identifiers() map[string]string {
+	identifiers := make(map[string]string)
+	for _, identifier := range md.Identifiers {
+		if typ, val, ok := identifier.calibreIdentifier(); ok {
+			identifiers[typ] = val
+		}
+	}
+	return identifiers
+}
+
+// rating returns the rating in stars from Calibre's meta element, which holds
+// it out of 10, or zero if it is not set
+func (md *Metadata) rating() float64 {
+	rating, err := strconv.ParseFloat(md.named("calibre:rating"), 64)
+	if err != nil {
+		return 0
+	}
+	return rating / 2
+}
@@ -0,0 +1,179 @@
+package calibre
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// finishedProgress is the progress past which a book counts as finished
+	finishedProgress = 0.99
+	// defaultResumeLength is how many characters ResumeReading returns by
+	// default
+	defaultResumeLength = 2000
+)
+
+// ReadingPosition is where a user stopped reading a format of a book in
+// Calibre's viewer, from last_read_positions. Progress is the fraction of the
+// book before it, from 0 to 1.
+type ReadingPosition struct {
+	Format   string  `json:"format"`
+	User     string  `json:"user"`
+	Device   string  `json:"device"`
+	CFI      string  `json:"cfi"`
+	Progress float64 `json:"progress"`
+	LastRead string  `json:"last_read"`
+}
+
+// ReadingBook is a book being read, with its most recent position
+type ReadingBook struct {
+	BookID   int             `json:"book_id"`
+	Title    string          `json:"title"`
+	Authors  []string        `json:"authors"`
+	Position ReadingPosition `json:"position"`
+}
+
+// ResumePoint is the place where the reading of a book stopped, with the text
+// that follows it. Text goes on into the next chapters when the chapter ends
+// first.
+type ResumePoint struct {
+	BookID   int             `json:"book_id"`
+	Title    string          `json:"title"`
+	Position ReadingPosition `json:"position"`
+	Location *Location       `json:"location"`
+	Text     string          `json:"text"`
+}
+
+// getReadingPositions returns the reading positions of a book, most recent
+// first. Libraries made before Calibre 5 have none.
+func getReadingPositions(ctx context.Context, db *DB, bookID int) ([]ReadingPosition, error) {
+	positions := make([]ReadingPosition, 0)
+	if ok, err := hasTable(ctx, db, "last_read_positions"); err != nil || !ok {
+		return positions, err
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT format, user, device, cfi, pos_frac, epoch
+		FROM last_read_positions
+		WHERE book = ?
+		ORDER BY epoch DESC
+	`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p ReadingPosition
+		var epoch float64
+		if err := rows.Scan(&p.Format, &p.User, &p.Device, &p.CFI, &p.Progress, &epoch); err != nil {
+			return nil, err
+		}
+		p.LastRead = time.Unix(int64(epoch), 0).UTC().Format(time.RFC3339)
+		positions = append(positions, p)
+	}
+	return positions, rows.Err()
+}
+
+// GetCurrentlyReading returns the books started but not finished in Calibre's
+// viewer, most recently read first, judging by the latest position of each
+// book
+func GetCurrentlyReading(ctx context.Context, db *DB, limit int) ([]ReadingBook, error) {
+	books := make([]ReadingBook, 0)
+	if ok, err := hasTable(ctx, db, "last_read_positions"); err != nil || !ok {
+		return books, err
+	}
+	query := `
+		SELECT b.id, b.title, p.format, p.user, p.device, p.cfi, p.pos_frac, p.epoch
+		FROM last_read_positions p
+		JOIN books b ON p.book = b.id
+		WHERE p.epoch = (SELECT MAX(epoch) FROM last_read_positions WHERE book = p.book)
+		  AND p.pos_frac > 0 AND p.pos_frac < ?
+		GROUP BY b.id
+		ORDER BY p.epoch DESC`
+	args := []any{finishedProgress}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var book ReadingBook
+		var epoch float64
+		p := &book.Position
+		if err := rows.Scan(&book.BookID, &book.Title, &p.Format, &p.User, &p.Device, &p.CFI, &p.Progress, &epoch); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		p.LastRead = time.Unix(int64(epoch), 0).UTC().Format(time.RFC3339)
+		books = append(books, book)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range books {
+		if books[i].Authors, err = getAuthorsForBook(db, books[i].BookID); err != nil {
+			return nil, err
+		}
+	}
+	return books, nil
+}
+
+// ResumeReading returns where the reading of the EPUB of a book stopped most
+// recently, with up to length characters of the text that follows
+func ResumeReading(ctx context.Context, db *DB, libraryPath string, bookID int, length int) (*ResumePoint, error) {
+	if length <= 0 {
+		length = defaultResumeLength
+	}
+	positions, err := getReadingPositions(ctx, db, bookID)
+	if err != nil {
+		return nil, err
+	}
+	resume := &ResumePoint{BookID: bookID}
+	found := false
+	for _, p := range positions {
+		if strings.EqualFold(p.Format, "EPUB") {
+			resume.Position, found = p, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("book %d has no reading position in an EPUB", bookID)
+	}
+	if err := db.QueryRowContext(ctx, "SELECT title FROM books WHERE id = ?", bookID).Scan(&resume.Title); err != nil {
+		return nil, err
+	}
+
+	r, err := openEPUBReader(db, libraryPath, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	doc, start, _, err := r.resolveCFI(resume.Position.CFI, -1)
+	if err != nil {
+		return nil, err
+	}
+	resume.Location = doc.location(start, start)
+
+	text := doc.text[resume.Location.Offset:]
+	for index := doc.index + 1; len(text) < length && index < len(r.pkg.Spine.Itemrefs); index++ {
+		next, err := r.chapter(index)
+		if err != nil {
+			continue
+		}
+		if len(next.text) > 0 {
+			text = append(append(append([]rune{}, text...), '\n', '\n'), next.text...)
+		}
+	}
+	if len(text) > length {
+		text = text[:length]
+	}
+	resume.Text = string(text)
+	return resume, nil
+}
@@ -63,7 +63,8 @@ func Search(ctx context.Context, db *DB, query string, opts ...SearchOption) (*S
 
 	sqlQuery := `
  		SELECT DISTINCT b.id, b.title, s.name, b.series_index, p.name, b.pubdate,
- 		       b.isbn, l.lang_code, r.rating, c.text, b.timestamp, b.last_modified
+ 		       b.isbn, l.lang_code, r.rating, c.text, b.timestamp, b.last_modified,
+ 		       b.path, b.has_cover
  		FROM books b
  		LEFT JOIN books_series_link bsl ON b.id = bsl.book
  		LEFT JOIN series s ON bsl.series = s.id
@@ -106,9 +107,11 @@ func Search(ctx context.Context, db *DB, query string, opts ...SearchOption) (*S
 		var language sql.NullString
 		var rating sql.NullInt32
 		var comments sql.NullString
+		var bookPath string
+		var hasCover bool
 		err := rows.Scan(&book.ID, &book.Title, &series, &book.SeriesIndex, &publisher,
 			&book.PubDate, &book.Isbn, &language, &rating,
-			&comments, &book.Timestamp, &book.LastModified)
+			&comments, &book.Timestamp, &book.LastModified, &bookPath, &hasCover)
 		if err != nil {
 			return nil, err
 		}
@@ -132,6 +135,7 @@ func Search(ctx context.Context, db *DB, query string, opts ...SearchOption) (*S
 		if !comments.Valid {
 			book.Comments = ""
 		}
+		book.Cover = coverPath(bookPath, hasCover)
 
 		// Get authors
 		book.Authors, err = getAuthorsForBook(db, book.ID)
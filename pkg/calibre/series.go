@@ -0,0 +1,151 @@
+package calibre
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// maxSeriesGaps is how many missing indices of a series are listed
+const maxSeriesGaps = 50
+
+// GetSeriesBooks returns the books of a series in series index order
+func GetSeriesBooks(ctx context.Context, db *DB, series string) ([]Book, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT b.id
+		FROM books b
+		JOIN books_series_link bsl ON b.id = bsl.book
+		JOIN series s ON bsl.series = s.id
+		WHERE s.name = ? COLLATE NOCASE
+		ORDER BY b.series_index, b.id
+	`, series)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("series not found")
+	}
+
+	books := make([]Book, 0, len(ids))
+	for _, id := range ids {
+		book, err := GetBook(ctx, db, id)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book.Book)
+	}
+	return books, nil
+}
+
+// SeriesVolume is an owned book of a series. Read is only set when the read
+// state is known.
+type SeriesVolume struct {
+	Book
+	Read *bool `json:"read,omitempty"`
+}
+
+// Series describes the owned volumes of a series in reading order, the
+// indices missing between them, the indices shared by several books (such as
+// two editions of a volume), and the next volume to read. GapsOmitted counts
+// the missing indices left out of Gaps, which lists at most maxSeriesGaps.
+type Series struct {
+	Name             string         `json:"name"`
+	Volumes          []SeriesVolume `json:"volumes"`
+	Gaps             []float64      `json:"gaps"`
+	GapsOmitted      int            `json:"gaps_omitted,omitempty"`
+	DuplicateIndices []float64      `json:"duplicate_indices"`
+	ReadColumn       string         `json:"read_column,omitempty"`
+	Next             *SeriesVolume  `json:"next,omitempty"`
+}
+
+// GetSeries returns the owned volumes of a series with the reading state held
+// by the custom column with the given lookup name, such as #read. The read
+// state is left unknown when readColumn is empty or not a column of the
+// library, and the next volume is then the first one.
+func GetSeries(ctx context.Context, db *DB, name string, readColumn string) (*Series, error) {
+	books, err := GetSeriesBooks(ctx, db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	column, err := getCustomColumn(ctx, db, readColumn)
+	if err != nil {
+		return nil, err
+	}
+
+	series := &Series{
+		Name:             books[0].Series,
+		Volumes:          make([]SeriesVolume, 0, len(books)),
+		Gaps:             []float64{},
+		DuplicateIndices: []float64{},
+	}
+	if column != nil {
+		series.ReadColumn = "#" + column.label
+	}
+	for _, book := range books {
+		volume := SeriesVolume{Book: book}
+		if column != nil {
+			read, err := column.readState(ctx, db, book.ID)
+			if err != nil {
+				return nil, err
+			}
+			volume.Read = &read
+		}
+		series.Volumes = append(series.Volumes, volume)
+	}
+
+	// Volumes are sorted by index, so duplicates are adjacent. Whole indices
+	// between the first and last whole ones are expected, so that series
+	// numbered by year don't miss every year before, while fractional ones,
+	// often used for novellas, are not.
+	previous := math.NaN()
+	for i, volume := range series.Volumes {
+		index := volume.SeriesIndex
+		if index == math.Trunc(index) && index != previous {
+			for gap := previous + 1; gap < index; gap++ {
+				if len(series.Gaps) == maxSeriesGaps {
+					series.GapsOmitted += int(index - gap)
+					break
+				}
+				series.Gaps = append(series.Gaps, gap)
+			}
+			previous = index
+		}
+		if i > 0 && index == series.Volumes[i-1].SeriesIndex &&
+			(i < 2 || series.Volumes[i-2].SeriesIndex != index) {
+			series.DuplicateIndices = append(series.DuplicateIndices, index)
+		}
+	}
+
+	// The next volume is the first unread one whose index wasn't read in
+	// another edition
+	read := make(map[float64]bool)
+	for _, volume := range series.Volumes {
+		if volume.Read != nil && *volume.Read {
+			read[volume.SeriesIndex] = true
+		}
+	}
+	for i, volume := range series.Volumes {
+		if !read[volume.SeriesIndex] {
+			series.Next = &series.Volumes[i]
+			break
+		}
+	}
+
+	return series, nil
+}
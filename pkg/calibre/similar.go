@@ -0,0 +1,73 @@
+package calibre
+
+import (
+	"context"
+)
+
+// SimilarBook is a book related to another one, scored by the authors, series
+// and tags they share
+type SimilarBook struct {
+	Book
+	Score int `json:"score"`
+}
+
+// FindSimilarBooks returns the books that share the most authors, series and
+// tags with the given book, best matches first
+func FindSimilarBooks(ctx context.Context, db *DB, bookID int, limit int) ([]SimilarBook, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+
+	// Shared authors weigh the most, then a shared series, then each shared tag
+	rows, err := db.QueryContext(ctx, `
+		SELECT book, SUM(weight) AS score
+		FROM (
+			SELECT l2.book AS book, 3 AS weight
+			FROM books_authors_link l1
+			JOIN books_authors_link l2 ON l1.author = l2.author
+			WHERE l1.book = ? AND l2.book != l1.book
+			UNION ALL
+			SELECT l2.book, 2
+			FROM books_series_link l1
+			JOIN books_series_link l2 ON l1.series = l2.series
+			WHERE l1.book = ? AND l2.book != l1.book
+			UNION ALL
+			SELECT l2.book, 1
+			FROM books_tags_link l1
+			JOIN books_tags_link l2 ON l1.tag = l2.tag
+			WHERE l1.book = ? AND l2.book != l1.book
+		)
+		GROUP BY book
+		ORDER BY score DESC, book
+		LIMIT ?
+	`, bookID, bookID, bookID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var similar []SimilarBook
+	for rows.Next() {
+		var s SimilarBook
+		if err := rows.Scan(&s.ID, &s.Score); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		similar = append(similar, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range similar {
+		book, err := GetBook(ctx, db, similar[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		similar[i].Book = book.Book
+	}
+	if similar == nil {
+		similar = []SimilarBook{}
+	}
+	return similar, nil
+}
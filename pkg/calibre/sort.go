@@ -0,0 +1,56 @@
+package calibre
+
+import (
+	"regexp"
+	"strings"
+)
+
+// titleArticles matches the leading articles Calibre moves to the end of
+// titles when sorting them, with its default English articles
+var titleArticles = regexp.MustCompile(`^(?i)(A|The|An)\s+`)
+
+// authorPrefixes are the honorifics Calibre drops when sorting author names
+var authorPrefixes = map[string]bool{"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true}
+
+// authorSuffixes are kept at the end of sorted author names
+var authorSuffixes = map[string]bool{
+	"jr": true, "sr": true, "inc": true, "ph.d": true, "phd": true, "md": true, "m.d": true,
+	"i": true, "ii": true, "iii": true, "iv": true, "junior": true, "senior": true,
+}
+
+// titleSort returns the sort key Calibre stores for a title, with the leading
+// article moved to the end: "The Hobbit" sorts as "Hobbit, The"
+func titleSort(title string) string {
+	title = strings.TrimSpace(title)
+	match := titleArticles.FindStringSubmatch(title)
+	if match == nil || len(match[0]) == len(title) {
+		return title
+	}
+	return title[len(match[0]):] + ", " + match[1]
+}
+
+// authorSort returns the sort key Calibre generates for an author name, with
+// the last name first: "Ursula K. Le Guin" sorts as "Guin, Ursula K. Le".
+// Names that already contain a comma are kept as they are.
+func authorSort(author string) string {
+	author = strings.TrimSpace(author)
+	if strings.Contains(author, ",") {
+		return author
+	}
+
+	tokens := strings.Fields(author)
+	for len(tokens) > 1 && authorPrefixes[strings.TrimRight(strings.ToLower(tokens[0]), ".")] {
+		tokens = tokens[1:]
+	}
+	var suffixes []string
+	for len(tokens) > 1 && authorSuffixes[strings.TrimRight(strings.ToLower(tokens[len(tokens)-1]), ".")] {
+		suffixes = append([]string{tokens[len(tokens)-1]}, suffixes...)
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) < 2 {
+		return author
+	}
+
+	sorted := append([]string{tokens[len(tokens)-1] + ","}, tokens[:len(tokens)-1]...)
+	return strings.Join(append(sorted, suffixes...), " ")
+}
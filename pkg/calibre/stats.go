@@ -0,0 +1,339 @@
+package calibre
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// statsTopLimit caps the tags and authors listed in library statistics
+const statsTopLimit = 20
+
+// statsLargestLimit is the number of largest books listed in library statistics
+const statsLargestLimit = 10
+
+// StatsCount is the number of books with a given language, tag, author, etc.
+type StatsCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type TagRating struct {
+	Tag           string  `json:"tag"`
+	AverageRating float64 `json:"average_rating"`
+	RatedBooks    int     `json:"rated_books"`
+}
+
+type FormatStorage struct {
+	Format string `json:"format"`
+	Files  int    `json:"files"`
+	Size   int64  `json:"size"`
+}
+
+type BookSize struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Size  int64  `json:"size"`
+}
+
+// LibraryStats summarizes the library, or the subset of it matching a search
+// query. Ratings are in stars, from 0.5 to 5.
+type LibraryStats struct {
+	Query               string          `json:"query,omitempty"`
+	Books               int             `json:"books"`
+	Authors             int             `json:"authors"`
+	Series              int             `json:"series"`
+	Tags                int             `json:"tags"`
+	Files               int             `json:"files"`
+	TotalSize           int64           `json:"total_size"`
+	AddedThisYear       int             `json:"added_this_year"`
+	ByLanguage          []StatsCount    `json:"by_language"`
+	ByFormat            []StatsCount    `json:"by_format"`
+	TopTags             []StatsCount    `json:"top_tags"`
+	TopAuthors          []StatsCount    `json:"top_authors"`
+	ByDecade            []StatsCount    `json:"by_publication_decade"`
+	ByAddedMonth        []StatsCount    `json:"by_added_month"`
+	RatingHistogram     []StatsCount    `json:"rating_histogram"`
+	AverageRatingByTag  []TagRating     `json:"average_rating_by_tag"`
+	StorageByFormat     []FormatStorage `json:"storage_by_format"`
+	LargestBooks        []BookSize      `json:"largest_books"`
+	BooksWithoutFormats []BookSize      `json:"books_without_formats"`
+}
+
+type statsBook struct {
+	title  string
+	added  string // YYYY-MM
+	decade string
+	rating float64
+	size   int64
+}
+
+// GetLibraryStats computes statistics over the books matching query, as
+// understood by Search, or over the whole library when query is empty
+func GetLibraryStats(ctx context.Context, db *DB, query string) (*LibraryStats, error) {
+	var subset map[int]bool
+	if query != "" {
+		results, err := Search(ctx, db, query)
+		if err != nil {
+			return nil, err
+		}
+		subset = make(map[int]bool, len(results.Books))
+		for _, book := range results.Books {
+			subset[book.ID] = true
+		}
+	}
+	included := func(id int) bool {
+		return subset == nil || subset[id]
+	}
+
+	// Load the books with their rating and size
+	rows, err := db.QueryContext(ctx, `
+		SELECT b.id, b.title,
+		       COALESCE(strftime('%Y-%m', b.timestamp), ''),
+		       COALESCE(strftime('%Y', b.pubdate), ''),
+		       COALESCE(r.rating, 0),
+		       COALESCE((SELECT SUM(d.uncompressed_size) FROM data d WHERE d.book = b.id), 0)
+		FROM books b
+		LEFT JOIN books_ratings_link brl ON b.id = brl.book
+		LEFT JOIN ratings r ON brl.rating = r.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	books := make(map[int]*statsBook)
+	for rows.Next() {
+		var id, rating int
+		var year string
+		var book statsBook
+		if err := rows.Scan(&id, &book.title, &book.added, &year, &rating, &book.size); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !included(id) {
+			continue
+		}
+		// Calibre stores an undefined publication date as year 101
+		if y, err := strconv.Atoi(year); err == nil && y > 101 {
+			book.decade = fmt.Sprintf("%ds", y/10*10)
+		}
+		book.rating = float64(rating) / 2
+		books[id] = &book
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := &LibraryStats{
+		Query:               query,
+		Books:               len(books),
+		AverageRatingByTag:  []TagRating{},
+		StorageByFormat:     []FormatStorage{},
+		LargestBooks:        []BookSize{},
+		BooksWithoutFormats: []BookSize{},
+	}
+
+	thisYear := strconv.Itoa(time.Now().Year())
+	decades := make(map[string]int)
+	months := make(map[string]int)
+	ratings := make(map[string]int)
+	for id, book := range books {
+		stats.TotalSize += book.size
+		if len(book.added) >= 4 && book.added[:4] == thisYear {
+			stats.AddedThisYear++
+		}
+		if book.decade != "" {
+			decades[book.decade]++
+		}
+		if book.added != "" {
+			months[book.added]++
+		}
+		if book.rating > 0 {
+			ratings[strconv.FormatFloat(book.rating, 'f', -1, 64)]++
+		} else {
+			ratings["unrated"]++
+		}
+		stats.LargestBooks = append(stats.LargestBooks, BookSize{ID: id, Title: book.title, Size: book.size})
+	}
+	stats.ByDecade = sortedByName(decades)
+	stats.ByAddedMonth = sortedByName(months)
+	stats.RatingHistogram = sortedByName(ratings)
+
+	sort.Slice(stats.LargestBooks, func(i, j int) bool {
+		if stats.LargestBooks[i].Size != stats.LargestBooks[j].Size {
+			return stats.LargestBooks[i].Size > stats.LargestBooks[j].Size
+		}
+		return stats.LargestBooks[i].ID < stats.LargestBooks[j].ID
+	})
+	if len(stats.LargestBooks) > statsLargestLimit {
+		stats.LargestBooks = stats.LargestBooks[:statsLargestLimit]
+	}
+
+	// Count the books of each language, author, series and tag
+	links := []struct {
+		query  string
+		counts *[]StatsCount
+		total  *int
+	}{
+		{`SELECT l.book, g.lang_code FROM books_languages_link l JOIN languages g ON l.lang_code = g.id`, &stats.ByLanguage, nil},
+		{`SELECT l.book, a.name FROM books_authors_link l JOIN authors a ON l.author = a.id`, &stats.TopAuthors, &stats.Authors},
+		{`SELECT l.book, s.name FROM books_series_link l JOIN series s ON l.series = s.id`, nil, &stats.Series},
+		{`SELECT l.book, t.name FROM books_tags_link l JOIN tags t ON l.tag = t.id`, &stats.TopTags, &stats.Tags},
+	}
+	for _, link := range links {
+		counts, err := countLinks(ctx, db, link.query, books)
+		if err != nil {
+			return nil, err
+		}
+		if link.total != nil {
+			*link.total = len(counts)
+		}
+		if link.counts != nil {
+			*link.counts = sortedByCount(counts)
+		}
+	}
+	if len(stats.TopAuthors) > statsTopLimit {
+		stats.TopAuthors = stats.TopAuthors[:statsTopLimit]
+	}
+	if len(stats.TopTags) > statsTopLimit {
+		stats.TopTags = stats.TopTags[:statsTopLimit]
+	}
+
+	// Average the ratings of the books of each tag
+	tagRatings := make(map[string][]float64)
+	err = forEachLink(ctx, db, `SELECT l.book, t.name FROM books_tags_link l JOIN tags t ON l.tag = t.id`, func(id int, tag string) {
+		if book, ok := books[id]; ok && book.rating > 0 {
+			tagRatings[tag] = append(tagRatings[tag], book.rating)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for tag, values := range tagRatings {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		stats.AverageRatingByTag = append(stats.AverageRatingByTag, TagRating{
+			Tag:           tag,
+			AverageRating: float64(int(sum/float64(len(values))*100+0.5)) / 100,
+			RatedBooks:    len(values),
+		})
+	}
+	sort.Slice(stats.AverageRatingByTag, func(i, j int) bool {
+		a, b := stats.AverageRatingByTag[i], stats.AverageRatingByTag[j]
+		if a.RatedBooks != b.RatedBooks {
+			return a.RatedBooks > b.RatedBooks
+		}
+		return fold(a.Tag) < fold(b.Tag)
+	})
+	if len(stats.AverageRatingByTag) > statsTopLimit {
+		stats.AverageRatingByTag = stats.AverageRatingByTag[:statsTopLimit]
+	}
+
+	// Count the books and storage of each format
+	rows, err = db.QueryContext(ctx, `
+		SELECT book, format, COALESCE(uncompressed_size, 0)
+		FROM data
+	`)
+	if err != nil {
+		return nil, err
+	}
+	formats := make(map[string]int)
+	storage := make(map[string]*FormatStorage)
+	withFormats := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		var format string
+		var size int64
+		if err := rows.Scan(&id, &format, &size); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if _, ok := books[id]; !ok {
+			continue
+		}
+		withFormats[id] = true
+		formats[format]++
+		if storage[format] == nil {
+			storage[format] = &FormatStorage{Format: format}
+		}
+		storage[format].Files++
+		storage[format].Size += size
+		stats.Files++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	stats.ByFormat = sortedByCount(formats)
+	for _, s := range storage {
+		stats.StorageByFormat = append(stats.StorageByFormat, *s)
+	}
+	sort.Slice(stats.StorageByFormat, func(i, j int) bool {
+		return stats.StorageByFormat[i].Size > stats.StorageByFormat[j].Size
+	})
+
+	for id, book := range books {
+		if !withFormats[id] {
+			stats.BooksWithoutFormats = append(stats.BooksWithoutFormats, BookSize{ID: id, Title: book.title})
+		}
+	}
+	sort.Slice(stats.BooksWithoutFormats, func(i, j int) bool {
+		return stats.BooksWithoutFormats[i].ID < stats.BooksWithoutFormats[j].ID
+	})
+
+	return stats, nil
+}
+
+// countLinks counts the books of each value of a link query returning book
+// IDs and names, ignoring books outside of the given set
+func countLinks(ctx context.Context, db *DB, query string, books map[int]*statsBook) (map[string]int, error) {
+	counts := make(map[string]int)
+	err := forEachLink(ctx, db, query, func(id int, name string) {
+		if _, ok := books[id]; ok {
+			counts[name]++
+		}
+	})
+	return counts, err
+}
+
+func forEachLink(ctx context.Context, db *DB, query string, fn func(id int, name string)) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var name sql.NullString
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		fn(id, name.String)
+	}
+	return rows.Err()
+}
+
+func sortedByCount(counts map[string]int) []StatsCount {
+	items := sortedByName(counts)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Count > items[j].Count
+	})
+	return items
+}
+
+func sortedByName(counts map[string]int) []StatsCount {
+	items := make([]StatsCount, 0, len(counts))
+	for name, count := range counts {
+		items = append(items, StatsCount{Name: name, Count: count})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return fold(items[i].Name) < fold(items[j].Name)
+	})
+	return items
+}
@@ -0,0 +1,307 @@
+package calibre
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultSummaryChunkSize is the number of characters of text sent in a
+// single summarization request
+const DefaultSummaryChunkSize = 12000
+
+// bookSummaryIndex is the chapter index under which whole-book summaries are cached
+const bookSummaryIndex = -1
+
+// SampleFunc asks a language model to respond to a prompt. The MCP server
+// implements it with sampling requests to the client.
+type SampleFunc func(ctx context.Context, prompt string) (string, error)
+
+type Summary struct {
+	BookID       int    `json:"book_id"`
+	ChapterIndex *int   `json:"chapter_index,omitempty"`
+	Summary      string `json:"summary"`
+	Cached       bool   `json:"cached"`
+}
+
+// SummaryCache persists summaries in a local SQLite database, keyed by book ID
+// and last modification time so that edited books are summarized again
+type SummaryCache struct {
+	db      *sql.DB
+	library string
+}
+
+// OpenSummaryCache opens or creates the summary cache at path, for the
+// library at libraryPath
+func OpenSummaryCache(path string, libraryPath string) (*SummaryCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS summaries (
+			library TEXT NOT NULL,
+			book INTEGER NOT NULL,
+			chapter INTEGER NOT NULL,
+			last_modified TEXT NOT NULL,
+			summary TEXT NOT NULL,
+			PRIMARY KEY (library, book, chapter)
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create summary cache: %w", err)
+	}
+	library, err := filepath.Abs(libraryPath)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SummaryCache{db: db, library: library}, nil
+}
+
+func (c *SummaryCache) get(ctx context.Context, bookID int, chapterIndex int, lastModified string) (string, bool, error) {
+	var summary string
+	err := c.db.QueryRowContext(ctx, `
+		SELECT summary
+		FROM summaries
+		WHERE library = ? AND book = ? AND chapter = ? AND last_modified = ?
+	`, c.library, bookID, chapterIndex, lastModified).Scan(&summary)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return summary, true, nil
+}
+
+func (c *SummaryCache) put(ctx context.Context, bookID int, chapterIndex int, lastModified string, summary string) error {
+	_, err := c.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO summaries (library, book, chapter, last_modified, summary)
+		VALUES (?, ?, ?, ?, ?)
+	`, c.library, bookID, chapterIndex, lastModified, summary)
+	return err
+}
+
+// Summarizer summarizes chapters and books map-reduce style: long texts are
+// split into chunks that are summarized separately, and the partial summaries
+// are then summarized together
+type Summarizer struct {
+	db          *DB
+	libraryPath string
+	cache       *SummaryCache
+	sample      SampleFunc
+	chunkSize   int
+}
+
+// NewSummarizer returns a summarizer that sends its prompts to sample. The
+// cache may be nil to disable caching.
+func NewSummarizer(db *DB, libraryPath string, cache *SummaryCache, sample SampleFunc) *Summarizer {
+	return &Summarizer{
+		db:          db,
+		libraryPath: libraryPath,
+		cache:       cache,
+		sample:      sample,
+		chunkSize:   DefaultSummaryChunkSize,
+	}
+}
+
+// SummarizeChapter summarizes a chapter of an EPUB book
+func (s *Summarizer) SummarizeChapter(ctx context.Context, bookID int, chapterIndex int) (*Summary, error) {
+	lastModified, err := s.lastModified(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	summary, cached, err := s.chapterSummary(ctx, bookID, chapterIndex, lastModified)
+	if err != nil {
+		return nil, err
+	}
+	return &Summary{BookID: bookID, ChapterIndex: &chapterIndex, Summary: summary, Cached: cached}, nil
+}
+
+// SummarizeBook summarizes a whole EPUB book from the summaries of its chapters
+func (s *Summarizer) SummarizeBook(ctx context.Context, bookID int) (*Summary, error) {
+	lastModified, err := s.lastModified(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if summary, ok := s.cached(ctx, bookID, bookSummaryIndex, lastModified); ok {
+		return &Summary{BookID: bookID, Summary: summary, Cached: true}, nil
+	}
+
+	chapters, err := GetEPUBChapters(s.db, s.libraryPath, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	var chapterSummaries []string
+	for _, chapter := range chapters {
+		summary, _, err := s.chapterSummary(ctx, bookID, chapter.Index, lastModified)
+		if err != nil {
+			return nil, err
+		}
+		if summary != "" {
+			chapterSummaries = append(chapterSummaries, fmt.Sprintf("%s:\n%s", chapter.Title, summary))
+		}
+	}
+	if len(chapterSummaries) == 0 {
+		return nil, fmt.Errorf("book %d has no text to summarize", bookID)
+	}
+
+	summary, err := s.reduce(ctx, chapterSummaries, "the chapters of a book, in reading order")
+	if err != nil {
+		return nil, err
+	}
+	s.store(ctx, bookID, bookSummaryIndex, lastModified, summary)
+
+	return &Summary{BookID: bookID, Summary: summary}, nil
+}
+
+func (s *Summarizer) chapterSummary(ctx context.Context, bookID int, chapterIndex int, lastModified string) (string, bool, error) {
+	if summary, ok := s.cached(ctx, bookID, chapterIndex, lastModified); ok {
+		return summary, true, nil
+	}
+
+	content, err := GetEPUBChapterContent(s.db, s.libraryPath, bookID, chapterIndex)
+	if err != nil {
+		return "", false, err
+	}
+	if strings.TrimSpace(content) == "" {
+		return "", false, nil
+	}
+
+	// Map: summarize each chunk of the chapter
+	chunks := splitIntoChunks(content, s.chunkSize)
+	partials := make([]string, 0, len(chunks))
+	for i, chunk := range chunks {
+		partial, err := s.sample(ctx, fmt.Sprintf(
+			"Summarize the following text (part %d of %d of a book chapter). "+
+				"Keep the key events, arguments, names and facts.\n\n%s",
+			i+1, len(chunks), chunk))
+		if err != nil {
+			return "", false, fmt.Errorf("sampling failed: %w", err)
+		}
+		partials = append(partials, partial)
+	}
+
+	// Reduce: combine the partial summaries
+	summary := partials[0]
+	if len(partials) > 1 {
+		summary, err = s.reduce(ctx, partials, "consecutive parts of a book chapter")
+		if err != nil {
+			return "", false, err
+		}
+	}
+	s.store(ctx, bookID, chapterIndex, lastModified, summary)
+
+	return summary, false, nil
+}
+
+// reduce summarizes a list of summaries into one, in several rounds if they
+// don't fit in a single chunk
+func (s *Summarizer) reduce(ctx context.Context, summaries []string, description string) (string, error) {
+	for {
+		groups := splitIntoChunks(strings.Join(summaries, "\n\n"), s.chunkSize)
+		next := make([]string, 0, len(groups))
+		for _, group := range groups {
+			summary, err := s.sample(ctx, fmt.Sprintf(
+				"The following are summaries of %s. Combine them into a single coherent summary "+
+					"that keeps the overall structure and the most important points.\n\n%s",
+				description, group))
+			if err != nil {
+				return "", fmt.Errorf("sampling failed: %w", err)
+			}
+			next = append(next, summary)
+		}
+		if len(next) == 1 {
+			return next[0], nil
+		}
+		if len(next) >= len(summaries) {
+			// The summaries are too long to ever fit together
+			return strings.Join(next, "\n\n"), nil
+		}
+		summaries = next
+	}
+}
+
+func (s *Summarizer) lastModified(ctx context.Context, bookID int) (string, error) {
+	var lastModified string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT last_modified
+		FROM books
+		WHERE id = ?
+	`, bookID).Scan(&lastModified)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("book not found")
+	}
+	return lastModified, err
+}
+
+func (s *Summarizer) cached(ctx context.Context, bookID int, chapterIndex int, lastModified string) (string, bool) {
+	if s.cache == nil {
+		return "", false
+	}
+	summary, ok, err := s.cache.get(ctx, bookID, chapterIndex, lastModified)
+	if err != nil {
+		return "", false
+	}
+	return summary, ok
+}
+
+func (s *Summarizer) store(ctx context.Context, bookID int, chapterIndex int, lastModified string, summary string) {
+	if s.cache == nil {
+		return
+	}
+	// A failure to cache only costs a new summary next time
+	_ = s.cache.put(ctx, bookID, chapterIndex, lastModified, summary)
+}
+
+// splitIntoChunks splits text on line boundaries into chunks of at most size
+// bytes, or of a single character when size is smaller. Lines longer than
+// size are split on their own.
+func splitIntoChunks(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		for len(line) > size {
+			if current.Len() > 0 {
+				chunks = append(chunks, current.String())
+				current.Reset()
+			}
+			cut := size
+			if i := strings.LastIndexByte(line[:size], ' '); i > 0 {
+				cut = i
+			}
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				// A size below the length of the first character still
+				// takes that character, so that the line gets shorter
+				_, cut = utf8.DecodeRuneInString(line)
+			}
+			chunks = append(chunks, line[:cut])
+			line = strings.TrimSpace(line[cut:])
+		}
+		if current.Len() > 0 && current.Len()+len(line)+1 > size {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteByte('\n')
+		}
+		current.WriteString(line)
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
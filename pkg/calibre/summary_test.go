@@ -0,0 +1,295 @@
+package calibre
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// fakeSampler stands in for the client's language model, answering map
+// prompts with numbered partial summaries and reduce prompts with a summary
+// of the summaries
+type fakeSampler struct {
+	prompts []string
+}
+
+func (f *fakeSampler) sample(ctx context.Context, prompt string) (string, error) {
+	f.prompts = append(f.prompts, prompt)
+	if strings.HasPrefix(prompt, "The following are summaries") {
+		return fmt.Sprintf("combined %d", len(f.prompts)), nil
+	}
+	return fmt.Sprintf("partial %d", len(f.prompts)), nil
+}
+
+// count returns how many prompts started with prefix
+func (f *fakeSampler) count(prefix string) int {
+	n := 0
+	for _, prompt := range f.prompts {
+		if strings.HasPrefix(prompt, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+const (
+	mapPrompt    = "Summarize the following text"
+	reducePrompt = "The following are summaries"
+)
+
+// newTestLibrary creates a library holding book 1, an EPUB with a chapter
+// for each of the given lists of paragraphs
+func newTestLibrary(t *testing.T, chapters ...[]string) (*DB, string) {
+	t.Helper()
+	libraryPath := t.TempDir()
+	bookPath := filepath.Join("Author", "Book (1)")
+	if err := os.MkdirAll(filepath.Join(libraryPath, bookPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(libraryPath, bookPath, "Book.epub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	files := map[string]string{
+		"META-INF/container.xml": `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`,
+	}
+	var manifest, spine strings.Builder
+	for i, paragraphs := range chapters {
+		fmt.Fprintf(&manifest, `<item id="c%d" href="c%d.xhtml" media-type="application/xhtml+xml"/>`, i, i)
+		fmt.Fprintf(&spine, `<itemref idref="c%d"/>`, i)
+		files[fmt.Sprintf("c%d.xhtml", i)] = fmt.Sprintf(`<?xml version="1.0"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Chapter %d</title></head>
+<body><p>%s</p></body></html>`, i+1, strings.Join(paragraphs, "</p>\n<p>"))
+	}
+	files["content.opf"] = fmt.Sprintf(`<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+<metadata/><manifest>%s</manifest><spine>%s</spine>
+</package>`, manifest.String(), spine.String())
+	for name, content := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := sql.Open(driverName, filepath.Join(libraryPath, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	_, err = raw.Exec(`
+		CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT, path TEXT, last_modified TEXT);
+		CREATE TABLE data (id INTEGER PRIMARY KEY, book INTEGER, format TEXT, name TEXT);
+		INSERT INTO books VALUES (1, 'Book', ?, '2024-01-01 10:00:00+00:00');
+		INSERT INTO data VALUES (1, 1, 'EPUB', 'Book');
+	`, filepath.ToSlash(bookPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenLibrary(libraryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, libraryPath
+}
+
+// paragraphs returns n paragraphs of about 60 characters
+func paragraphs(n int) []string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = fmt.Sprintf("Paragraph %d tells what happened next in the long story.", i+1)
+	}
+	return ps
+}
+
+func TestSplitIntoChunks(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		size int
+		want []string
+	}{
+		{"empty", "", 10, nil},
+		{"fits", "one\ntwo", 10, []string{"one\ntwo"}},
+		{"exactly size", "aaaa\nbbbb", 9, []string{"aaaa\nbbbb"}},
+		{"one over size", "aaaa\nbbbb", 8, []string{"aaaa", "bbbb"}},
+		{"long line on spaces", "aaa bbb ccc", 7, []string{"aaa", "bbb ccc"}},
+		{"long line without spaces", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"long line after short", "x\naaaa bbbb", 5, []string{"x", "aaaa", "bbbb"}},
+		{"size below a character", "日本", 2, []string{"日", "本"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitIntoChunks(tt.text, tt.size)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("splitIntoChunks(%q, %d) = %q, want %q", tt.text, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitIntoChunksRunes(t *testing.T) {
+	text := strings.Repeat("é", 10) + "\n" + strings.Repeat("日本語", 5)
+	for _, chunk := range splitIntoChunks(text, 7) {
+		if len(chunk) > 7 {
+			t.Errorf("chunk %q is longer than 7 bytes", chunk)
+		}
+		if !utf8.ValidString(chunk) {
+			t.Errorf("chunk %q splits a character", chunk)
+		}
+	}
+}
+
+func TestSummarizeChapterSingleChunk(t *testing.T) {
+	db, libraryPath := newTestLibrary(t, paragraphs(3))
+	sampler := &fakeSampler{}
+	s := NewSummarizer(db, libraryPath, nil, sampler.sample)
+
+	summary, err := s.SummarizeChapter(context.Background(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Summary != "partial 1" {
+		t.Errorf("summary = %q, want the summary of the only chunk", summary.Summary)
+	}
+	if n := sampler.count(reducePrompt); n != 0 {
+		t.Errorf("%d reduce prompts for a single chunk", n)
+	}
+}
+
+func TestSummarizeChapterMapReduce(t *testing.T) {
+	db, libraryPath := newTestLibrary(t, paragraphs(20))
+	sampler := &fakeSampler{}
+	s := NewSummarizer(db, libraryPath, nil, sampler.sample)
+	s.chunkSize = 300
+
+	content, err := GetEPUBChapterContent(db, libraryPath, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := splitIntoChunks(content, s.chunkSize)
+	if len(chunks) < 3 {
+		t.Fatalf("chapter split into %d chunks, want several", len(chunks))
+	}
+
+	summary, err := s.SummarizeChapter(context.Background(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := sampler.count(mapPrompt); n != len(chunks) {
+		t.Errorf("%d map prompts, want one per chunk (%d)", n, len(chunks))
+	}
+	for i, chunk := range chunks {
+		if !strings.HasSuffix(sampler.prompts[i], chunk) {
+			t.Errorf("prompt %d does not end with chunk %d", i, i)
+		}
+	}
+	// The partial summaries fit in one chunk, so a single reduce combines them
+	if n := sampler.count(reducePrompt); n != 1 {
+		t.Fatalf("%d reduce prompts, want 1", n)
+	}
+	last := sampler.prompts[len(sampler.prompts)-1]
+	for i := range chunks {
+		if !strings.Contains(last, fmt.Sprintf("partial %d", i+1)) {
+			t.Errorf("reduce prompt is missing partial summary %d", i+1)
+		}
+	}
+	if want := fmt.Sprintf("combined %d", len(chunks)+1); summary.Summary != want {
+		t.Errorf("summary = %q, want %q", summary.Summary, want)
+	}
+}
+
+func TestSummarizeBook(t *testing.T) {
+	db, libraryPath := newTestLibrary(t, paragraphs(2), paragraphs(2), paragraphs(2))
+	sampler := &fakeSampler{}
+	s := NewSummarizer(db, libraryPath, nil, sampler.sample)
+
+	summary, err := s.SummarizeBook(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := sampler.count(mapPrompt); n != 3 {
+		t.Errorf("%d map prompts, want one per chapter", n)
+	}
+	last := sampler.prompts[len(sampler.prompts)-1]
+	if !strings.HasPrefix(last, reducePrompt) || !strings.Contains(last, "the chapters of a book") {
+		t.Fatalf("last prompt does not combine the chapter summaries: %q", last)
+	}
+	for i := 1; i <= 3; i++ {
+		if !strings.Contains(last, fmt.Sprintf("partial %d", i)) {
+			t.Errorf("book summary is missing the summary of chapter %d", i)
+		}
+	}
+	if summary.Summary != "combined 4" || summary.ChapterIndex != nil {
+		t.Errorf("summary = %+v, want the combined summary of the book", summary)
+	}
+}
+
+func TestSummaryCacheInvalidation(t *testing.T) {
+	db, libraryPath := newTestLibrary(t, paragraphs(3))
+	cache, err := OpenSummaryCache(filepath.Join(t.TempDir(), "cache", "summaries.db"), libraryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sampler := &fakeSampler{}
+	s := NewSummarizer(db, libraryPath, cache, sampler.sample)
+	ctx := context.Background()
+
+	first, err := s.SummarizeChapter(ctx, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Cached {
+		t.Error("first summary is marked as cached")
+	}
+
+	second, err := s.SummarizeChapter(ctx, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Cached || second.Summary != first.Summary || len(sampler.prompts) != 1 {
+		t.Errorf("second summary = %+v after %d prompts, want the cached one", second, len(sampler.prompts))
+	}
+
+	// Editing the book in Calibre changes last_modified
+	if _, err := db.Exec("UPDATE books SET last_modified = '2024-06-01 10:00:00+00:00' WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	third, err := s.SummarizeChapter(ctx, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Cached || len(sampler.prompts) != 2 {
+		t.Errorf("summary after an edit = %+v after %d prompts, want a new one", third, len(sampler.prompts))
+	}
+
+	fourth, err := s.SummarizeChapter(ctx, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fourth.Cached || fourth.Summary != third.Summary {
+		t.Errorf("summary = %+v, want the one cached after the edit", fourth)
+	}
+}
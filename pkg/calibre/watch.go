@@ -0,0 +1,197 @@
+package calibre
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// LibraryChange lists the books that were added, changed or removed between
+// two polls of the library
+type LibraryChange struct {
+	Added   []int `json:"added"`
+	Changed []int `json:"changed"`
+	Removed []int `json:"removed"`
+}
+
+// Empty reports whether the change affects no book
+func (c *LibraryChange) Empty() bool {
+	return len(c.Added) == 0 && len(c.Changed) == 0 && len(c.Removed) == 0
+}
+
+type bookState struct {
+	path         string
+	lastModified string
+	dirModTime   time.Time
+}
+
+// Watcher detects changes made to the library by other programs, such as the
+// Calibre GUI, by polling metadata.db and the book folders
+type Watcher struct {
+	db          *DB
+	libraryPath string
+	interval    time.Duration
+
+	conn        *sql.Conn
+	dbModTime   time.Time
+	dataVersion int64
+	books       map[int]bookState
+}
+
+func NewWatcher(db *DB, libraryPath string, interval time.Duration) *Watcher {
+	return &Watcher{
+		db:          db,
+		libraryPath: libraryPath,
+		interval:    interval,
+	}
+}
+
+// Watch polls the library until the context is done, calling onChange after
+// every poll that found changes
+func (w *Watcher) Watch(ctx context.Context, onChange func(*LibraryChange)) error {
+	defer w.close()
+
+	// Take the initial snapshot, which is not reported as a change
+	if _, err := w.Poll(ctx); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		change, err := w.Poll(ctx)
+		if err != nil {
+			// The database may be locked while Calibre writes to it, so try
+			// again on the next tick
+			continue
+		}
+		if !change.Empty() {
+			onChange(change)
+		}
+	}
+}
+
+// Poll compares the library with the previous poll. The first poll records a
+// snapshot and reports no change.
+func (w *Watcher) Poll(ctx context.Context) (*LibraryChange, error) {
+	if w.conn == nil {
+		// PRAGMA data_version is only meaningful on a single connection
+		conn, err := w.db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		w.conn = conn
+	}
+
+	var dataVersion int64
+	if err := w.conn.QueryRowContext(ctx, "PRAGMA data_version").Scan(&dataVersion); err != nil {
+		return nil, err
+	}
+	dbModTime := w.databaseModTime()
+
+	previous := w.books
+	books := previous
+	if previous == nil || dataVersion != w.dataVersion || !dbModTime.Equal(w.dbModTime) {
+		var err error
+		books, err = w.snapshot(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// The database didn't change, but files may have been replaced on disk
+		books = make(map[int]bookState, len(previous))
+		for id, state := range previous {
+			state.dirModTime = w.dirModTime(state.path)
+			books[id] = state
+		}
+	}
+
+	w.dataVersion = dataVersion
+	w.dbModTime = dbModTime
+	w.books = books
+
+	change := &LibraryChange{
+		Added:   []int{},
+		Changed: []int{},
+		Removed: []int{},
+	}
+	if previous == nil {
+		return change, nil
+	}
+	for id, state := range books {
+		old, ok := previous[id]
+		if !ok {
+			change.Added = append(change.Added, id)
+		} else if old != state {
+			change.Changed = append(change.Changed, id)
+		}
+	}
+	for id := range previous {
+		if _, ok := books[id]; !ok {
+			change.Removed = append(change.Removed, id)
+		}
+	}
+	slices.Sort(change.Added)
+	slices.Sort(change.Changed)
+	slices.Sort(change.Removed)
+
+	return change, nil
+}
+
+func (w *Watcher) snapshot(ctx context.Context) (map[int]bookState, error) {
+	rows, err := w.conn.QueryContext(ctx, `
+		SELECT id, path, last_modified
+		FROM books
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := make(map[int]bookState)
+	for rows.Next() {
+		var id int
+		var state bookState
+		if err := rows.Scan(&id, &state.path, &state.lastModified); err != nil {
+			return nil, err
+		}
+		state.dirModTime = w.dirModTime(state.path)
+		books[id] = state
+	}
+	return books, rows.Err()
+}
+
+// databaseModTime returns the latest modification time of metadata.db and
+// its write-ahead log
+func (w *Watcher) databaseModTime() time.Time {
+	var latest time.Time
+	for _, name := range []string{"metadata.db", "metadata.db-wal"} {
+		if info, err := os.Stat(filepath.Join(w.libraryPath, name)); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (w *Watcher) dirModTime(bookPath string) time.Time {
+	info, err := os.Stat(filepath.Join(w.libraryPath, bookPath))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (w *Watcher) close() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}